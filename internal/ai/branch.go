@@ -0,0 +1,175 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ============================
+// Branching history
+// ============================
+
+// DefaultBranch is the branch a new thread starts on.
+const DefaultBranch = "main"
+
+// threadBranch is a named line of development within a thread.
+type threadBranch struct {
+	head    int
+	session Session
+}
+
+// BranchInfo describes one branch of a thread.
+type BranchInfo struct {
+	Name    string
+	Head    int
+	Turns   []int
+	Current bool
+}
+
+func (t *ImageThread) nextID() int {
+	id := 0
+	for _, n := range t.nodes {
+		if n.ID > id {
+			id = n.ID
+		}
+	}
+	return id + 1
+}
+
+func (t *ImageThread) node(id int) *ThreadTurn {
+	for _, n := range t.nodes {
+		if n.ID == id {
+			return n
+		}
+	}
+	return nil
+}
+
+// path returns the turns from the first generation down to id, oldest first.
+func (t *ImageThread) path(id int) []*ThreadTurn {
+	var out []*ThreadTurn
+	for n := t.node(id); n != nil; n = t.node(n.Parent) {
+		out = append(out, n)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// depthOn returns the 1-based position of turn id on branch b, or 0 if the turn is
+// not an ancestor of (or equal to) the branch head.
+func (t *ImageThread) depthOn(b *threadBranch, id int) int {
+	for i, n := range t.path(b.head) {
+		if n.ID == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Branch returns the name of the current branch.
+func (t *ImageThread) Branch() string { return t.branch }
+
+// Branches lists every branch, sorted by name.
+func (t *ImageThread) Branches() []BranchInfo {
+	out := make([]BranchInfo, 0, len(t.branches))
+	for name, b := range t.branches {
+		info := BranchInfo{Name: name, Head: b.head, Current: name == t.branch}
+		for _, n := range t.path(b.head) {
+			info.Turns = append(info.Turns, n.ID)
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Fork creates a new branch named name whose head is turn id and checks it out.
+// The next message continues from that turn while every other branch is kept.
+func (t *ImageThread) Fork(name string, id int) error {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, " \t/") {
+		return fmt.Errorf("invalid branch name %q", name)
+	}
+	if _, exists := t.branches[name]; exists {
+		return fmt.Errorf("branch %q already exists", name)
+	}
+	if t.node(id) == nil {
+		return fmt.Errorf("no turn %d in this thread", id)
+	}
+	// Prefer the current branch; any branch passing through the turn holds its history.
+	names := append([]string{t.branch}, t.branchNames()...)
+	for _, from := range names {
+		b := t.branches[from]
+		depth := t.depthOn(b, id)
+		if depth == 0 {
+			continue
+		}
+		session, err := t.provider.ForkSession(b.session, depth)
+		if err != nil {
+			return err
+		}
+		t.branches[name] = &threadBranch{head: id, session: session}
+		t.branch = name
+		return nil
+	}
+	return fmt.Errorf("turn %d is not reachable from any branch", id)
+}
+
+// Checkout switches the current branch.
+func (t *ImageThread) Checkout(name string) error {
+	if _, ok := t.branches[name]; !ok {
+		return fmt.Errorf("no branch named %q", name)
+	}
+	t.branch = name
+	return nil
+}
+
+// Undo moves the current branch back one turn so the next message continues from
+// the previous image. The dropped turn is discarded unless another branch still
+// includes it. The initial generation cannot be undone.
+func (t *ImageThread) Undo() error {
+	b := t.branches[t.branch]
+	path := t.path(b.head)
+	if len(path) <= 1 {
+		return errors.New("nothing to undo: only the initial generation remains")
+	}
+	session, err := t.provider.ForkSession(b.session, len(path)-1)
+	if err != nil {
+		return err
+	}
+	dropped := b.head
+	b.session = session
+	b.head = path[len(path)-2].ID
+	if !t.reachable(dropped) {
+		for i, n := range t.nodes {
+			if n.ID == dropped {
+				t.nodes = append(t.nodes[:i], t.nodes[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// reachable reports whether any branch includes turn id.
+func (t *ImageThread) reachable(id int) bool {
+	for _, b := range t.branches {
+		if t.depthOn(b, id) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *ImageThread) branchNames() []string {
+	names := make([]string, 0, len(t.branches))
+	for n := range t.branches {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,57 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rkirkendall/nano-agent/internal/cassette"
+)
+
+func replayCassette(t *testing.T, name string) {
+	t.Helper()
+	player, err := cassette.Load("testdata/cassettes/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OPENROUTER_API_KEY", "")
+	t.Setenv("OPENROUTER_BASE_URL", "")
+	t.Setenv("OPENROUTER_MODEL", "")
+	SetHTTPTransport(player, true)
+	t.Cleanup(func() { SetHTTPTransport(nil, false) })
+}
+
+func TestReplayOpenRouterImage(t *testing.T) {
+	replayCassette(t, "openrouter_image")
+	img, err := GenerateImage(context.Background(), "openrouter/google/gemini-3-pro-image-preview", nil, "a green pixel", nil, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(img, []byte("\x89PNG")) {
+		t.Fatalf("expected PNG bytes, got %q", img[:8])
+	}
+
+	// The cassette holds the request body's hash, so a different prompt misses.
+	replayCassette(t, "openrouter_image")
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	defer SetRetryPolicy(DefaultRetryPolicy)
+	if _, err := GenerateImage(context.Background(), "openrouter/google/gemini-3-pro-image-preview", nil, "a red pixel", nil, nil, "", ""); err == nil || !strings.Contains(err.Error(), "no recorded interaction") {
+		t.Fatalf("expected no match for a different request body, got %v", err)
+	}
+}
+
+func TestReplayOpenRouterOutagePage(t *testing.T) {
+	replayCassette(t, "openrouter_outage")
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 1}) // the cassette holds a single response
+	defer SetRetryPolicy(DefaultRetryPolicy)
+	_, err := GenerateImage(context.Background(), "openrouter/google/gemini-3-pro-image-preview", nil, "a green pixel", nil, nil, "", "")
+	var pe *ProviderError
+	if !errors.As(err, &pe) || pe.Class != ClassOutage || pe.Status != 503 {
+		t.Fatalf("expected a classified outage, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "HTTP 503") {
+		t.Fatalf("expected status in error, got %v", err)
+	}
+}
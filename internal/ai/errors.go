@@ -0,0 +1,273 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// Errors returned by generation and critique calls can be told apart with
+// errors.Is. Provider failures are *ProviderError values (with the HTTP status and
+// a body preview) that match ErrQuota, ErrRateLimited, ErrAuth, ErrProviderOutage
+// or ErrSafetyBlocked by class; a response refused by safety filters is a
+// *SafetyBlockedError, and one that finished without the image or critique
+// text asked for is a *NoImageError or *NoTextError.
+var (
+	// ErrNoImage means the model answered but its response held no image.
+	ErrNoImage = errors.New("no image returned by model")
+	// ErrNoText means a critique request was answered without any text.
+	ErrNoText = errors.New("no text returned by model")
+	// ErrSafetyBlocked means the prompt or the response was blocked by content
+	// safety filters.
+	ErrSafetyBlocked = errors.New("blocked by safety filters")
+	// ErrQuota means the provider refused the call because a quota or the
+	// account's credit ran out.
+	ErrQuota = errors.New("quota exceeded")
+	// ErrRateLimited means the provider kept rate limiting the call after retries.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrAuth means the API key is missing, invalid or not allowed to use the model.
+	ErrAuth = errors.New("authentication failed")
+	// ErrProviderOutage means the provider kept failing with server errors,
+	// timeouts or outage pages after retries.
+	ErrProviderOutage = errors.New("provider outage")
+)
+
+// Is lets errors.Is match a ProviderError against the sentinel errors by class.
+func (e *ProviderError) Is(target error) bool {
+	switch target {
+	case ErrQuota:
+		return e.Class == ClassQuota
+	case ErrRateLimited:
+		return e.Class == ClassRateLimit
+	case ErrAuth:
+		return e.Class == ClassAuth
+	case ErrSafetyBlocked:
+		return e.Class == ClassBlocked
+	case ErrProviderOutage:
+		return e.Class == ClassOutage || e.Class == ClassTransient
+	}
+	return false
+}
+
+// SafetyRating is a provider's harm assessment for one category.
+type SafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability,omitempty"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+func (r SafetyRating) String() string {
+	s := strings.TrimPrefix(r.Category, "HARM_CATEGORY_")
+	if r.Probability != "" {
+		s += "=" + r.Probability
+	}
+	if r.Blocked {
+		s += " (blocked)"
+	}
+	return s
+}
+
+// PromptFeedback is a provider's verdict on the prompt itself.
+type PromptFeedback struct {
+	BlockReason   string         `json:"block_reason,omitempty"`
+	Message       string         `json:"message,omitempty"`
+	SafetyRatings []SafetyRating `json:"safety_ratings,omitempty"`
+}
+
+// ResponseInfo is what a provider reported about a response that produced no
+// image: why the candidate finished, how it was rated, what was said about the
+// prompt and any text the model wrote instead (often a refusal).
+type ResponseInfo struct {
+	// FinishReason is the candidate's finish reason, e.g. IMAGE_SAFETY,
+	// MAX_TOKENS or (OpenRouter) content_filter.
+	FinishReason   string          `json:"finish_reason,omitempty"`
+	SafetyRatings  []SafetyRating  `json:"safety_ratings,omitempty"`
+	PromptFeedback *PromptFeedback `json:"prompt_feedback,omitempty"`
+	Text           string          `json:"text,omitempty"`
+}
+
+// textPreviewLimit bounds the model text quoted in an error message.
+const textPreviewLimit = 300
+
+// describe lists the parts of the response worth showing in an error message.
+func (r ResponseInfo) describe() []string {
+	var why []string
+	if pf := r.PromptFeedback; pf != nil && pf.BlockReason != "" {
+		why = append(why, "prompt blocked: "+pf.BlockReason)
+		if pf.Message != "" {
+			why = append(why, pf.Message)
+		}
+		for _, sr := range pf.SafetyRatings {
+			if sr.Blocked {
+				why = append(why, sr.String())
+			}
+		}
+	}
+	if r.FinishReason != "" {
+		why = append(why, "finish reason "+r.FinishReason)
+	}
+	for _, sr := range r.SafetyRatings {
+		if sr.Blocked {
+			why = append(why, sr.String())
+		}
+	}
+	if t := strings.Join(strings.Fields(r.Text), " "); t != "" {
+		if len(t) > textPreviewLimit {
+			t = t[:textPreviewLimit] + "..."
+		}
+		why = append(why, fmt.Sprintf("model said: %q", t))
+	}
+	return why
+}
+
+// blocked reports whether safety filters stopped the prompt or the response.
+func (r ResponseInfo) blocked() bool {
+	if r.PromptFeedback != nil && r.PromptFeedback.BlockReason != "" {
+		return true
+	}
+	return blockedFinishReasons[r.FinishReason]
+}
+
+// blockedFinishReasons are the finish reasons that mean a safety filter stopped
+// the response.
+var blockedFinishReasons = map[string]bool{
+	string(genai.FinishReasonSafety):                 true,
+	string(genai.FinishReasonRecitation):             true,
+	string(genai.FinishReasonBlocklist):              true,
+	string(genai.FinishReasonProhibitedContent):      true,
+	string(genai.FinishReasonSPII):                   true,
+	string(genai.FinishReasonImageSafety):            true,
+	string(genai.FinishReasonImageProhibitedContent): true,
+	"content_filter":                                 true,
+}
+
+// NoImageError reports a response that finished without an image for a reason
+// other than safety filters. It matches ErrNoImage.
+type NoImageError struct {
+	Provider string
+	ResponseInfo
+}
+
+func (e *NoImageError) Error() string { return responseError(e.Provider, ErrNoImage, e.ResponseInfo) }
+
+func (e *NoImageError) Is(target error) bool { return target == ErrNoImage }
+
+// NoTextError reports a critique response that finished without text for a
+// reason other than safety filters. It matches ErrNoText.
+type NoTextError struct {
+	Provider string
+	ResponseInfo
+}
+
+func (e *NoTextError) Error() string { return responseError(e.Provider, ErrNoText, e.ResponseInfo) }
+
+func (e *NoTextError) Is(target error) bool { return target == ErrNoText }
+
+// SafetyBlockedError reports a prompt or response refused by safety filters. It
+// matches ErrSafetyBlocked.
+type SafetyBlockedError struct {
+	Provider string
+	ResponseInfo
+}
+
+func (e *SafetyBlockedError) Error() string {
+	return responseError(e.Provider, ErrSafetyBlocked, e.ResponseInfo)
+}
+
+func (e *SafetyBlockedError) Is(target error) bool { return target == ErrSafetyBlocked }
+
+func responseError(provider string, sentinel error, r ResponseInfo) string {
+	msg := fmt.Sprintf("%s: %s", provider, sentinel)
+	if why := r.describe(); len(why) > 0 {
+		msg += " (" + strings.Join(why, "; ") + ")"
+	}
+	return msg
+}
+
+// noImageError returns the typed error for a response without an image.
+func noImageError(provider string, r ResponseInfo) error {
+	if r.blocked() {
+		return &SafetyBlockedError{Provider: provider, ResponseInfo: r}
+	}
+	return &NoImageError{Provider: provider, ResponseInfo: r}
+}
+
+// noTextError returns the typed error for a critique response without text.
+func noTextError(provider string, r ResponseInfo) error {
+	if r.blocked() {
+		return &SafetyBlockedError{Provider: provider, ResponseInfo: r}
+	}
+	return &NoTextError{Provider: provider, ResponseInfo: r}
+}
+
+// ResponseOf returns the response details carried by a *NoImageError,
+// *NoTextError or *SafetyBlockedError in err's chain, or nil.
+func ResponseOf(err error) *ResponseInfo {
+	var ne *NoImageError
+	if errors.As(err, &ne) {
+		return &ne.ResponseInfo
+	}
+	var te *NoTextError
+	if errors.As(err, &te) {
+		return &te.ResponseInfo
+	}
+	var se *SafetyBlockedError
+	if errors.As(err, &se) {
+		return &se.ResponseInfo
+	}
+	return nil
+}
+
+// geminiResponseInfo collects the finish reason, safety ratings, prompt feedback
+// and text of a Gemini response.
+func geminiResponseInfo(res *genai.GenerateContentResponse, text string) ResponseInfo {
+	r := ResponseInfo{Text: text}
+	if pf := res.PromptFeedback; pf != nil && (pf.BlockReason != "" || len(pf.SafetyRatings) > 0) {
+		r.PromptFeedback = &PromptFeedback{
+			BlockReason:   string(pf.BlockReason),
+			Message:       pf.BlockReasonMessage,
+			SafetyRatings: geminiSafetyRatings(pf.SafetyRatings),
+		}
+	}
+	if len(res.Candidates) > 0 && res.Candidates[0] != nil {
+		c := res.Candidates[0]
+		r.FinishReason = string(c.FinishReason)
+		r.SafetyRatings = geminiSafetyRatings(c.SafetyRatings)
+	}
+	return r
+}
+
+func geminiSafetyRatings(in []*genai.SafetyRating) []SafetyRating {
+	var out []SafetyRating
+	for _, r := range in {
+		if r == nil {
+			continue
+		}
+		out = append(out, SafetyRating{Category: string(r.Category), Probability: string(r.Probability), Blocked: r.Blocked})
+	}
+	return out
+}
+
+// openRouterResponseInfo collects the finish reason and text of a chat
+// completion. The upstream provider's own finish reason (e.g. Gemini's
+// IMAGE_SAFETY) is preferred to OpenRouter's normalized one; a refusal is used
+// as the text when the message has no content.
+func openRouterResponseInfo(m map[string]any, text string) ResponseInfo {
+	r := ResponseInfo{Text: text}
+	choices, _ := m["choices"].([]any)
+	if len(choices) == 0 {
+		return r
+	}
+	ch, _ := choices[0].(map[string]any)
+	if native, _ := ch["native_finish_reason"].(string); native != "" {
+		r.FinishReason = native
+	} else {
+		r.FinishReason, _ = ch["finish_reason"].(string)
+	}
+	if msg, _ := ch["message"].(map[string]any); msg != nil && r.Text == "" {
+		r.Text, _ = msg["refusal"].(string)
+	}
+	return r
+}
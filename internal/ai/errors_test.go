@@ -0,0 +1,153 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+func TestNoImageErrors(t *testing.T) {
+	res := &genai.GenerateContentResponse{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{BlockReason: genai.BlockedReasonProhibitedContent}}
+	err := noImageError(defaultProviderName, geminiResponseInfo(res, ""))
+	var se *SafetyBlockedError
+	if !errors.As(err, &se) || se.PromptFeedback == nil || se.PromptFeedback.BlockReason != "PROHIBITED_CONTENT" {
+		t.Fatalf("expected a blocked prompt, got %v", err)
+	}
+
+	res = &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonMaxTokens}}}
+	err = noImageError(defaultProviderName, geminiResponseInfo(res, "A long description"))
+	if !errors.Is(err, ErrNoImage) || errors.Is(err, ErrSafetyBlocked) {
+		t.Fatalf("expected ErrNoImage, got %v", err)
+	}
+	if r := ResponseOf(fmt.Errorf("panel 1: %w", err)); r == nil || r.FinishReason != "MAX_TOKENS" || r.Text != "A long description" {
+		t.Fatalf("ResponseOf = %+v", r)
+	}
+
+	m := map[string]any{"choices": []any{map[string]any{"finish_reason": "content_filter", "message": map[string]any{"refusal": "I can't help with that."}}}}
+	err = noImageError(openRouterProviderName, openRouterResponseInfo(m, ""))
+	if !errors.As(err, &se) || se.Text != "I can't help with that." {
+		t.Fatalf("expected an OpenRouter safety block with the refusal, got %v", err)
+	}
+}
+
+func TestNoTextErrors(t *testing.T) {
+	res := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonMaxTokens}}}
+	err := noTextError(defaultProviderName, geminiResponseInfo(res, ""))
+	if !errors.Is(err, ErrNoText) || errors.Is(err, ErrNoImage) {
+		t.Fatalf("expected ErrNoText, got %v", err)
+	}
+	if r := ResponseOf(err); r == nil || r.FinishReason != "MAX_TOKENS" {
+		t.Fatalf("ResponseOf = %+v", r)
+	}
+
+	m := map[string]any{"choices": []any{map[string]any{"finish_reason": "content_filter", "message": map[string]any{"content": ""}}}}
+	if _, err := parseTextFromChatJSON(m); !errors.Is(err, ErrSafetyBlocked) {
+		t.Fatalf("expected a filtered critique to be blocked, got %v", err)
+	}
+	m = map[string]any{"choices": []any{map[string]any{"finish_reason": "length", "message": map[string]any{"content": ""}}}}
+	if _, err := parseTextFromChatJSON(m); !errors.Is(err, ErrNoText) {
+		t.Fatalf("expected ErrNoText, got %v", err)
+	}
+}
+
+func TestProviderErrorSentinels(t *testing.T) {
+	cases := []struct {
+		class ErrorClass
+		want  error
+	}{
+		{ClassQuota, ErrQuota},
+		{ClassRateLimit, ErrRateLimited},
+		{ClassAuth, ErrAuth},
+		{ClassBlocked, ErrSafetyBlocked},
+		{ClassOutage, ErrProviderOutage},
+		{ClassTransient, ErrProviderOutage},
+	}
+	for _, c := range cases {
+		err := fmt.Errorf("panel 1: %w", &ProviderError{Provider: "gemini", Class: c.class, Status: 503})
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s does not match %v", c.class, c.want)
+		}
+		if errors.Is(err, ErrNoImage) || errors.Is(err, ErrNoText) {
+			t.Errorf("%s matches ErrNoImage or ErrNoText", c.class)
+		}
+	}
+	if errors.Is(&ProviderError{Class: ClassRateLimit}, ErrQuota) {
+		t.Error("rate limits must not match ErrQuota")
+	}
+	if errors.Is(&ProviderError{Class: ClassPermanent}, ErrProviderOutage) {
+		t.Error("permanent errors must not match ErrProviderOutage")
+	}
+}
+
+// stubTransport answers every request with one JSON response.
+type stubTransport struct{ body string }
+
+func (s stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(s.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestResponseErrors(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "test-key")
+	t.Setenv("OPENROUTER_BASE_URL", "")
+	t.Cleanup(func() { SetHTTPTransport(nil, false) })
+
+	cases := []struct {
+		name, model, body string
+		check             func(error) string
+	}{
+		{
+			name:  "gemini image safety",
+			model: "gemini-3-pro-image-preview",
+			body:  `{"candidates": [{"content": {"role": "model", "parts": [{"text": "I can't create that image."}]}, "finishReason": "IMAGE_SAFETY", "index": 0, "safetyRatings": [{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "HIGH", "blocked": true}, {"category": "HARM_CATEGORY_HARASSMENT", "probability": "NEGLIGIBLE"}]}]}`,
+			check: func(err error) string {
+				var se *SafetyBlockedError
+				if !errors.As(err, &se) || !errors.Is(err, ErrSafetyBlocked) {
+					return "expected a safety block"
+				}
+				if se.FinishReason != "IMAGE_SAFETY" || len(se.SafetyRatings) != 2 || se.Text != "I can't create that image." {
+					return fmt.Sprintf("unexpected response details %+v", se.ResponseInfo)
+				}
+				if !strings.Contains(err.Error(), "DANGEROUS_CONTENT=HIGH (blocked)") {
+					return "expected the blocked category in the message"
+				}
+				return ""
+			},
+		},
+		{
+			name:  "openrouter text instead of an image",
+			model: "openrouter/google/gemini-3-pro-image-preview",
+			body:  `{"choices": [{"index": 0, "finish_reason": "length", "native_finish_reason": "MAX_TOKENS", "message": {"role": "assistant", "content": "Here is a description of the scene instead of an image."}}]}`,
+			check: func(err error) string {
+				var ne *NoImageError
+				if !errors.As(err, &ne) || !errors.Is(err, ErrNoImage) {
+					return "expected ErrNoImage"
+				}
+				if ne.FinishReason != "MAX_TOKENS" || !strings.Contains(err.Error(), "description of the scene") {
+					return "expected the native finish reason and the model's text in the message"
+				}
+				return ""
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			SetHTTPTransport(stubTransport{body: c.body}, true)
+			_, err := GenerateImage(context.Background(), c.model, nil, "a green pixel", nil, nil, "", "")
+			if msg := c.check(err); msg != "" {
+				t.Fatalf("%s, got %v", msg, err)
+			}
+		})
+	}
+}
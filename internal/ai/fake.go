@@ -0,0 +1,190 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// ============================
+// Offline fake provider
+// ============================
+
+const fakeProviderName = "fake"
+
+// fakeImageBase is the long edge, in pixels, of images synthesized by the fake provider.
+const fakeImageBase = 256
+
+func init() {
+	RegisterProvider(fakeProviderName, fakeProvider{})
+}
+
+// fakeProvider is an offline provider selected with the "fake/" model prefix. It needs
+// no API key and returns deterministic output: images are patterns seeded by a hash of
+// the conversation so far, and critiques are canned JSON matching the critique schema.
+type fakeProvider struct{}
+
+// fakeSession chains a digest of every message so each turn yields a distinct image.
+type fakeSession struct {
+	Model   string           `json:"model"`
+	Config  GenerationConfig `json:"config"`
+	Digests []string         `json:"digests"`
+}
+
+func (fakeProvider) Capabilities(string) Capabilities {
+	return Capabilities{ImageConfig: true, Sampling: true, SystemInstruction: true, SafetySettings: true}
+}
+
+func (f fakeProvider) StartThread(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (Session, []byte, string, error) {
+	s := &fakeSession{Model: model, Config: cfg}
+	img, text, err := f.ContinueThread(ctx, s, parts)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return s, img, text, nil
+}
+
+func (fakeProvider) ContinueThread(ctx context.Context, s Session, parts []Part) ([]byte, string, error) {
+	fs, ok := s.(*fakeSession)
+	if !ok {
+		return nil, "", fmt.Errorf("fake: unexpected session type %T", s)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+	prev := ""
+	if len(fs.Digests) > 0 {
+		prev = fs.Digests[len(fs.Digests)-1]
+	}
+	sum := fakeDigest(prev, fs.Model, parts)
+	img, err := fakeImage(sum, fs.Config.AspectRatio)
+	if err != nil {
+		return nil, "", err
+	}
+	digest := hex.EncodeToString(sum[:])
+	fs.Digests = append(fs.Digests, digest)
+	return img, fmt.Sprintf("fake image %d (%s)", len(fs.Digests), digest[:12]), nil
+}
+
+func (fakeProvider) Critique(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	sum := fakeDigest("", model, parts)
+	id := hex.EncodeToString(sum[:4])
+	x := float64(sum[4]%50) / 100
+	y := float64(sum[5]%50) / 100
+	score := 5 + int(sum[6]%5)
+	return fmt.Sprintf(`{
+  "score": %d,
+  "scores": { "prompt_adherence": %d, "artifacts": %d, "reference_fidelity": null },
+  "keep_notes": ["overall composition", "color palette"],
+  "summary_keep": ["layout reads clearly"],
+  "summary_change": ["sharpen the focal region"],
+  "edits": [
+    {
+      "id": "fake-%s",
+      "target": {
+        "type": "region",
+        "label": "focal region",
+        "bbox": { "x": %.2f, "y": %.2f, "w": 0.25, "h": 0.25 },
+        "points": null
+      },
+      "priority": "MAJOR",
+      "instruction": "Increase contrast and edge definition inside the focal region.",
+      "rationale": "Deterministic critique from the offline fake provider.",
+      "done_when": "The focal region edges are crisp at 100%% zoom."
+    }
+  ]
+}`, score, score, 5+int(sum[7]%5), id, x, y), nil
+}
+
+func (fakeProvider) ForkSession(s Session, turns int) (Session, error) {
+	fs, ok := s.(*fakeSession)
+	if !ok {
+		return nil, fmt.Errorf("fake: unexpected session type %T", s)
+	}
+	if turns < 0 || turns > len(fs.Digests) {
+		return nil, fmt.Errorf("fake: cannot fork at turn %d of %d", turns, len(fs.Digests))
+	}
+	return &fakeSession{Model: fs.Model, Config: fs.Config, Digests: append([]string(nil), fs.Digests[:turns]...)}, nil
+}
+
+func (fakeProvider) DecodeSession(data []byte) (Session, error) {
+	var s fakeSession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("fake: decode session: %w", err)
+	}
+	return &s, nil
+}
+
+// fakeDigest hashes the previous digest, the model and every part of a message.
+func fakeDigest(prev, model string, parts []Part) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(prev))
+	h.Write([]byte{0})
+	h.Write([]byte(model))
+	for _, p := range parts {
+		h.Write([]byte{0})
+		h.Write([]byte(p.Text))
+		h.Write(p.Data)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// fakeImage renders a tiled pattern whose colors derive from seed and whose
+// dimensions follow aspectRatio ("W:H"), defaulting to square.
+func fakeImage(seed [sha256.Size]byte, aspectRatio string) ([]byte, error) {
+	w, h := fakeImageBase, fakeImageBase
+	if aw, ah, ok := parseAspectRatio(aspectRatio); ok {
+		// Extreme ratios (e.g. 1:300) would round the short side down to 0.
+		if aw >= ah {
+			h = max(1, fakeImageBase*ah/aw)
+		} else {
+			w = max(1, fakeImageBase*aw/ah)
+		}
+	}
+	const tiles = 8
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := (y*tiles/h)*tiles + x*tiles/w
+			b := seed[i%len(seed)]
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: b,
+				G: seed[(i+11)%len(seed)] ^ byte(x),
+				B: seed[(i+23)%len(seed)] ^ byte(y),
+				A: 0xff,
+			})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseAspectRatio parses "W:H" into positive integers.
+func parseAspectRatio(s string) (int, int, bool) {
+	a, b, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, 0, false
+	}
+	w, err1 := strconv.Atoi(a)
+	h, err2 := strconv.Atoi(b)
+	if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
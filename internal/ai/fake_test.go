@@ -0,0 +1,58 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFakeProviderDeterministic(t *testing.T) {
+	ctx := context.Background()
+	_, a, err := StartImageThreadAndGenerate(ctx, "fake/test", nil, "a red fox", nil, nil, "16:9", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread, b, err := StartImageThreadAndGenerate(ctx, "fake/test", nil, "a red fox", nil, nil, "16:9", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatal("expected identical images for identical prompts")
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 256 || cfg.Height != 144 {
+		t.Fatalf("expected 256x144 image, got %dx%d", cfg.Width, cfg.Height)
+	}
+	next, err := thread.AddUserMessageAndGenerate(ctx, "make it blue", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a, next) {
+		t.Fatal("expected a follow-up turn to change the image")
+	}
+}
+
+func TestFakeProviderCritiqueIsJSON(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "img.png")
+	if err := os.WriteFile(p, []byte("not really a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := GenerateCritique(context.Background(), "fake/test", p, "a red fox", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(out), &m); err != nil {
+		t.Fatalf("critique is not valid JSON: %v\n%s", err, out)
+	}
+	if _, ok := m["edits"]; !ok {
+		t.Fatal("expected edits in fake critique")
+	}
+}
@@ -0,0 +1,99 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	fallbackMu     sync.Mutex
+	fallbackModels []string
+)
+
+// SetFallbackModels sets the models tried, in order, when a generation or
+// critique fails with a transient provider error (rate limit, timeout or outage)
+// after its retries are exhausted. Models use the same provider prefixes as the
+// primary model, e.g. "openrouter/google/gemini-3-pro-image-preview".
+func SetFallbackModels(models []string) {
+	var out []string
+	for _, m := range models {
+		if m = strings.TrimSpace(m); m != "" {
+			out = append(out, m)
+		}
+	}
+	fallbackMu.Lock()
+	fallbackModels = out
+	fallbackMu.Unlock()
+}
+
+// routeChain returns model followed by the fallback models, without repeats.
+func routeChain(model string) []string {
+	fallbackMu.Lock()
+	defer fallbackMu.Unlock()
+	chain := []string{model}
+	seen := map[string]bool{model: true}
+	for _, m := range fallbackModels {
+		if !seen[m] {
+			seen[m] = true
+			chain = append(chain, m)
+		}
+	}
+	return chain
+}
+
+// route is the provider and model that served a request.
+type route struct {
+	provider Provider
+	name     string
+	model    string
+	effModel string
+}
+
+// walkRoutes calls try with model's provider and then with each fallback model's
+// provider until one succeeds. It only moves on when the failure is a retryable
+// *ProviderError; any other error is returned at once. Fallback models that
+// cannot honor cfg are skipped, while the primary model failing cfg.check is an
+// error. When every route fails the primary model's error is returned.
+func walkRoutes(model, op string, cfg GenerationConfig, try func(p Provider, effModel string) error) (route, error) {
+	var first error
+	chain := routeChain(model)
+	for i, m := range chain {
+		p, name, effModel, err := providerForModel(m)
+		if err != nil {
+			return route{}, err
+		}
+		if err := cfg.check(m, p.Capabilities(effModel)); err != nil {
+			if i == 0 {
+				return route{}, err
+			}
+			fmt.Fprintf(retryLog, "skipping fallback %s: %v\n", m, err)
+			continue
+		}
+		err = try(p, effModel)
+		if err == nil {
+			if i > 0 {
+				fmt.Fprintf(retryLog, "%s served by fallback %s/%s\n", op, name, effModel)
+			}
+			return route{provider: p, name: name, model: m, effModel: effModel}, nil
+		}
+		if first == nil {
+			first = err
+		}
+		if !canFallBack(err) {
+			return route{}, err
+		}
+		if i+1 < len(chain) {
+			fmt.Fprintf(retryLog, "%s with %s failed: %v; falling back to %s\n", op, m, err, chain[i+1])
+		}
+	}
+	return route{}, first
+}
+
+// canFallBack reports whether err is a transient provider failure that another
+// route might not share.
+func canFallBack(err error) bool {
+	var pe *ProviderError
+	return errors.As(err, &pe) && pe.Class.Retryable()
+}
@@ -0,0 +1,58 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// downProvider fails every image generation with err.
+type downProvider struct {
+	stubProvider
+	err   error
+	calls int
+}
+
+func (d *downProvider) StartThread(context.Context, string, []Part, GenerationConfig) (Session, []byte, string, error) {
+	d.calls++
+	return nil, nil, "", d.err
+}
+
+func TestFallbackModels(t *testing.T) {
+	stubSleep(t)
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	t.Cleanup(func() { SetFallbackModels(nil) })
+
+	down := &downProvider{err: &ProviderError{Provider: "down", Class: ClassOutage, Status: 503}}
+	RegisterProvider("down", down)
+	SetFallbackModels([]string{"down/other", "fake/test"})
+
+	thread, img, err := StartImageThreadAndGenerate(context.Background(), "down/primary", nil, "a lighthouse", nil, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img) == 0 || down.calls != 2 || thread.Model() != "fake/test" || thread.Route() != "fake/test" {
+		t.Fatalf("calls=%d model=%q route=%q", down.calls, thread.Model(), thread.Route())
+	}
+
+	critic := &flakyCritic{errs: []error{&ProviderError{Provider: "flaky", Class: ClassRateLimit, Status: 429}}}
+	RegisterProvider("flaky", critic)
+	SetFallbackModels([]string{"fake/test"})
+	path := filepath.Join(t.TempDir(), "img.png")
+	if err := os.WriteFile(path, img, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if text, err := GenerateCritique(context.Background(), "flaky/critic", path, "a lighthouse", nil, nil, nil); err != nil || text == "" || critic.calls != 1 {
+		t.Fatalf("critique = %q, %v after %d calls", text, err, critic.calls)
+	}
+
+	// Permanent failures are not passed on to fallbacks.
+	down.calls, down.err = 0, &ProviderError{Provider: "down", Class: ClassAuth, Status: 401}
+	_, _, err = StartImageThreadAndGenerate(context.Background(), "down/primary", nil, "a lighthouse", nil, nil, "", "")
+	var pe *ProviderError
+	if !errors.As(err, &pe) || pe.Class != ClassAuth || down.calls != 1 {
+		t.Fatalf("expected one auth failure, got %v after %d calls", err, down.calls)
+	}
+}
@@ -0,0 +1,522 @@
+package ai
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/openai/openai-go/v2"
+	"github.com/openai/openai-go/v2/option"
+)
+
+const (
+	openRouterProviderName   = "openrouter"
+	defaultOpenRouterBaseURL = "https://openrouter.ai/api/v1"
+)
+
+func init() {
+	RegisterProvider(openRouterProviderName, openRouterProvider{})
+}
+
+func ensureOpenRouterKey() error {
+	k := strings.TrimSpace(os.Getenv("OPENROUTER_API_KEY"))
+	if k == "" && !offline {
+		return fmt.Errorf("%w: OPENROUTER_API_KEY is required when using OpenRouter", ErrAuth)
+	}
+	return nil
+}
+
+func mapModelForOpenRouter(model string) string {
+	// Legacy environment override still respected if set
+	if env := strings.TrimSpace(os.Getenv("OPENROUTER_MODEL")); env != "" {
+		return env
+	}
+	m := strings.TrimSpace(model)
+	if m == "" {
+		return "google/gemini-3-pro-image-preview"
+	}
+	if strings.HasPrefix(m, "openrouter/") {
+		m = strings.TrimPrefix(m, "openrouter/")
+	}
+	// If it already looks like a provider/model string, trust it
+	if strings.Contains(m, "/") {
+		return m
+	}
+	// Heuristic: if it looks like a google model but has no provider prefix, add google/
+	if strings.Contains(m, ":") || strings.HasPrefix(m, "gemini-") {
+		return "google/" + m
+	}
+	return "google/" + m
+}
+
+func newOpenRouterClient() openai.Client {
+	return openai.NewClient(
+		option.WithAPIKey(os.Getenv("OPENROUTER_API_KEY")),
+		option.WithBaseURL(defaultOpenRouterBaseURL),
+	)
+}
+
+func getOpenRouterBaseURL() string {
+	b := strings.TrimSpace(os.Getenv("OPENROUTER_BASE_URL"))
+	if b != "" {
+		return b
+	}
+	return defaultOpenRouterBaseURL
+}
+
+// ============================
+// OpenRouter provider
+// ============================
+
+// openRouterProvider routes requests through OpenRouter's chat/completions API.
+type openRouterProvider struct{}
+
+// openRouterSession is the accumulated chat/completions message list of a thread
+// and the settings sent with each of its requests.
+type openRouterSession struct {
+	Model    string           `json:"model"`
+	Messages []any            `json:"messages"`
+	Config   GenerationConfig `json:"config,omitzero"`
+}
+
+func (openRouterProvider) Capabilities(string) Capabilities {
+	return Capabilities{Sampling: true, SystemInstruction: true}
+}
+
+func (o openRouterProvider) StartThread(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (Session, []byte, string, error) {
+	s := &openRouterSession{Model: model, Config: cfg}
+	img, text, err := o.ContinueThread(ctx, s, parts)
+	if err != nil {
+		return nil, nil, text, err
+	}
+	return s, img, text, nil
+}
+
+func (openRouterProvider) ContinueThread(ctx context.Context, s Session, parts []Part) ([]byte, string, error) {
+	ors, ok := s.(*openRouterSession)
+	if !ok {
+		return nil, "", fmt.Errorf("openrouter: unexpected session type %T", s)
+	}
+	if err := ensureOpenRouterKey(); err != nil {
+		return nil, "", err
+	}
+	messages := append(append([]any(nil), ors.Messages...), map[string]any{"role": "user", "content": openRouterContent(parts)})
+	img, text, err := openRouterGenerate(ctx, newOpenRouterClient(), ors.Model, messages, ors.Config)
+	if err != nil {
+		return nil, text, err
+	}
+	ors.Messages = append(messages, openRouterAssistantMessage(img, text)...)
+	return img, text, nil
+}
+
+func (openRouterProvider) Critique(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (string, error) {
+	if err := ensureOpenRouterKey(); err != nil {
+		return "", err
+	}
+	req := openRouterRequest(model, []any{
+		map[string]any{
+			"role":    "user",
+			"content": openRouterContent(parts),
+		},
+	}, cfg)
+	m, err := httpJSON(newOpenRouterClient(), ctx, "chat/completions", req)
+	if err != nil {
+		return "", err
+	}
+	return parseTextFromChatJSON(m)
+}
+
+func (openRouterProvider) ForkSession(s Session, turns int) (Session, error) {
+	ors, ok := s.(*openRouterSession)
+	if !ok {
+		return nil, fmt.Errorf("openrouter: unexpected session type %T", s)
+	}
+	// Every successful turn adds a user and an assistant message.
+	n := 2 * turns
+	if turns < 0 || n > len(ors.Messages) {
+		return nil, fmt.Errorf("openrouter: cannot fork at turn %d of %d", turns, len(ors.Messages)/2)
+	}
+	return &openRouterSession{Model: ors.Model, Messages: append([]any(nil), ors.Messages[:n]...), Config: ors.Config}, nil
+}
+
+func (openRouterProvider) DecodeSession(data []byte) (Session, error) {
+	var s openRouterSession
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("openrouter: decode session: %w", err)
+	}
+	return &s, nil
+}
+
+// openRouterContent converts neutral parts into chat/completions content parts.
+func openRouterContent(parts []Part) []any {
+	out := make([]any, 0, len(parts))
+	for _, p := range parts {
+		if p.IsImage() {
+			out = append(out, map[string]any{
+				"type":      "image_url",
+				"image_url": map[string]any{"url": toDataURL(p.MIMEType, p.Data)},
+			})
+			continue
+		}
+		if s := strings.TrimSpace(p.Text); s != "" {
+			out = append(out, map[string]any{"type": "text", "text": s})
+		}
+	}
+	return out
+}
+
+// openRouterRequest builds a chat/completions request body. The system
+// instruction is sent as a leading system message rather than stored in the
+// session, so a session's messages stay one user/assistant pair per turn.
+func openRouterRequest(model string, messages []any, cfg GenerationConfig) map[string]any {
+	if s := strings.TrimSpace(cfg.SystemInstruction); s != "" {
+		messages = append([]any{map[string]any{"role": "system", "content": s}}, messages...)
+	}
+	req := map[string]any{
+		"model":    mapModelForOpenRouter(model),
+		"messages": messages,
+	}
+	if cfg.Temperature != nil {
+		req["temperature"] = *cfg.Temperature
+	}
+	if cfg.TopP != nil {
+		req["top_p"] = *cfg.TopP
+	}
+	if cfg.Seed != nil {
+		req["seed"] = *cfg.Seed
+	}
+	return req
+}
+
+// openRouterGenerate performs a chat/completions call with the given messages and
+// returns the generated image bytes and any assistant text.
+func openRouterGenerate(ctx context.Context, client openai.Client, model string, messages []any, cfg GenerationConfig) ([]byte, string, error) {
+	req := openRouterRequest(model, messages, cfg)
+	m, err := httpJSON(client, ctx, "chat/completions", req)
+	if err != nil {
+		return nil, "", err
+	}
+	img, imgErr := parseImageFromChatJSON(m)
+	assistantText, _ := parseTextFromChatJSON(m)
+	if errors.Is(imgErr, ErrNoImage) {
+		imgErr = noImageError(openRouterProviderName, openRouterResponseInfo(m, assistantText))
+	}
+	if imgErr != nil {
+		return nil, assistantText, imgErr
+	}
+	return img, assistantText, nil
+}
+
+func openRouterAssistantMessage(img []byte, assistantText string) []any {
+	parts := make([]any, 0, 2)
+	if strings.TrimSpace(assistantText) != "" {
+		parts = append(parts, map[string]any{"type": "text", "text": assistantText})
+	}
+	if len(img) > 0 {
+		parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]any{"url": toDataURL("image/png", img)}})
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return []any{map[string]any{"role": "assistant", "content": parts}}
+}
+
+func httpJSON(client openai.Client, ctx context.Context, path string, body any) (map[string]any, error) {
+	// Use direct HTTP request to avoid SDK path quirks
+	path = strings.TrimLeft(path, "/")
+	base := getOpenRouterBaseURL()
+	url := strings.TrimRight(base, "/") + "/" + path
+
+	breq, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(breq)))
+	if err != nil {
+		return nil, err
+	}
+	k := strings.TrimSpace(os.Getenv("OPENROUTER_API_KEY"))
+	req.Header.Set("Authorization", "Bearer "+k)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	referer := strings.TrimSpace(os.Getenv("OPENROUTER_SITE"))
+	if referer == "" {
+		referer = "http://localhost"
+	}
+	req.Header.Set("HTTP-Referer", referer)
+	title := strings.TrimSpace(os.Getenv("OPENROUTER_TITLE"))
+	if title == "" {
+		title = "nano-agent"
+	}
+	req.Header.Set("X-Title", title)
+	req.Header.Set("User-Agent", "nano-agent/1.0 (+github.com/rkirkendall/nano-agent)")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("OPENROUTER_DEBUG") == "1" {
+		fmt.Fprintf(os.Stderr, "DEBUG openrouter POST %s status=%v auth=%t\n", url, resp.Status, strings.TrimSpace(k) != "")
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("OPENROUTER_DEBUG") == "1" {
+		preview := string(b)
+		if len(preview) > 4096 {
+			preview = preview[:4096] + "... [truncated]"
+		}
+		fmt.Fprintf(os.Stderr, "DEBUG openrouter BODY %s\n", preview)
+	}
+	if looksLikeHTML(b) || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, &ProviderError{
+			Provider: openRouterProviderName,
+			Class:    ClassOutage,
+			Status:   resp.StatusCode,
+			Message:  "OpenRouter returned an HTML error page instead of an API response",
+			Body:     previewBody(b),
+		}
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		if resp.StatusCode >= 400 {
+			return nil, &ProviderError{
+				Provider:   openRouterProviderName,
+				Class:      classifyStatus(resp.StatusCode, string(b)),
+				Status:     resp.StatusCode,
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+				Message:    http.StatusText(resp.StatusCode),
+				Body:       previewBody(b),
+			}
+		}
+		return nil, fmt.Errorf("openrouter decode failed: %w; status=%d; body=%s", err, resp.StatusCode, previewBody(b))
+	}
+	// Errors come back as {"error": {"code": 429, "message": "..."}}, sometimes
+	// with a 200 status.
+	if errObj, ok := out["error"].(map[string]any); ok || resp.StatusCode >= 400 {
+		status := resp.StatusCode
+		if code, ok := errObj["code"].(float64); ok && code >= 400 {
+			status = int(code)
+		}
+		msg, _ := errObj["message"].(string)
+		if strings.TrimSpace(msg) == "" {
+			msg = "OpenRouter returned an error"
+		}
+		return nil, &ProviderError{
+			Provider:   openRouterProviderName,
+			Class:      classifyStatus(status, msg),
+			Status:     status,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Message:    msg,
+			Body:       previewBody(b),
+		}
+	}
+	return out, nil
+}
+
+func toDataURL(mime string, b []byte) string {
+	return fmt.Sprintf("data:%s;base64,%s", mime, base64.StdEncoding.EncodeToString(b))
+}
+
+func parseImageFromResponsesJSON(m map[string]any) ([]byte, error) {
+	// Responses API shape
+	if outArr, ok := m["output"].([]any); ok {
+		for _, item := range outArr {
+			obj, _ := item.(map[string]any)
+			if obj == nil {
+				continue
+			}
+			contentArr, _ := obj["content"].([]any)
+			for _, c := range contentArr {
+				cobj, _ := c.(map[string]any)
+				if cobj == nil {
+					continue
+				}
+				t, _ := cobj["type"].(string)
+				if t == "output_image" || t == "image" || t == "image_url" {
+					if img, ok := cobj["image"].(map[string]any); ok {
+						if s, ok := img["b64_json"].(string); ok && s != "" {
+							return base64.StdEncoding.DecodeString(s)
+						}
+						if s, ok := img["b64"].(string); ok && s != "" {
+							return base64.StdEncoding.DecodeString(s)
+						}
+						if u, ok := img["url"].(string); ok && strings.HasPrefix(u, "data:") {
+							if i := strings.IndexByte(u, ','); i > 0 {
+								return base64.StdEncoding.DecodeString(u[i+1:])
+							}
+						}
+					}
+					if s, ok := cobj["b64_json"].(string); ok && s != "" {
+						return base64.StdEncoding.DecodeString(s)
+					}
+				}
+			}
+		}
+	}
+	// Images API style
+	if dataArr, ok := m["data"].([]any); ok {
+		for _, d := range dataArr {
+			dobj, _ := d.(map[string]any)
+			if dobj == nil {
+				continue
+			}
+			if s, ok := dobj["b64_json"].(string); ok && s != "" {
+				return base64.StdEncoding.DecodeString(s)
+			}
+			if u, ok := dobj["url"].(string); ok && strings.HasPrefix(u, "data:") {
+				if i := strings.IndexByte(u, ','); i > 0 {
+					return base64.StdEncoding.DecodeString(u[i+1:])
+				}
+			}
+		}
+	}
+	return nil, ErrNoImage
+}
+
+func parseImageFromChatJSON(m map[string]any) ([]byte, error) {
+	if choices, ok := m["choices"].([]any); ok && len(choices) > 0 {
+		ch, _ := choices[0].(map[string]any)
+		if ch != nil {
+			if msg, ok := ch["message"].(map[string]any); ok {
+				// Some providers return images in message.images (not within content parts)
+				if imgs, _ := msg["images"].([]any); len(imgs) > 0 {
+					if im0, _ := imgs[0].(map[string]any); im0 != nil {
+						// image_url wrapper
+						if iu, ok := im0["image_url"].(map[string]any); ok {
+							if u, _ := iu["url"].(string); strings.HasPrefix(u, "data:") {
+								if i := strings.IndexByte(u, ','); i > 0 {
+									return base64.StdEncoding.DecodeString(u[i+1:])
+								}
+							}
+						}
+						// nested image map
+						if imgMap, ok := im0["image"].(map[string]any); ok {
+							if s, ok := imgMap["b64_json"].(string); ok && s != "" {
+								return base64.StdEncoding.DecodeString(s)
+							}
+							if s, ok := imgMap["b64"].(string); ok && s != "" {
+								return base64.StdEncoding.DecodeString(s)
+							}
+							if u, ok := imgMap["url"].(string); ok && strings.HasPrefix(u, "data:") {
+								if i := strings.IndexByte(u, ','); i > 0 {
+									return base64.StdEncoding.DecodeString(u[i+1:])
+								}
+							}
+						}
+					}
+				}
+				// content can be string or array of parts
+				if parts, ok := msg["content"].([]any); ok {
+					for _, p := range parts {
+						pobj, _ := p.(map[string]any)
+						if pobj == nil {
+							continue
+						}
+						// Common shapes: image_url { url: data:... }, b64_json string, or output_image { image: { b64_json|b64|url } }
+						if t, _ := pobj["type"].(string); t == "image_url" || t == "image" || t == "output_image" {
+							// Nested image map
+							if img, ok := pobj["image"].(map[string]any); ok {
+								if s, ok := img["b64_json"].(string); ok && s != "" {
+									return base64.StdEncoding.DecodeString(s)
+								}
+								if s, ok := img["b64"].(string); ok && s != "" {
+									return base64.StdEncoding.DecodeString(s)
+								}
+								if u, ok := img["url"].(string); ok && strings.HasPrefix(u, "data:") {
+									if i := strings.IndexByte(u, ','); i > 0 {
+										return base64.StdEncoding.DecodeString(u[i+1:])
+									}
+								}
+							}
+							// image_url wrapper
+							if iu, ok := pobj["image_url"].(map[string]any); ok {
+								if u, _ := iu["url"].(string); strings.HasPrefix(u, "data:") {
+									if i := strings.IndexByte(u, ','); i > 0 {
+										return base64.StdEncoding.DecodeString(u[i+1:])
+									}
+								}
+							}
+							// direct b64 on the part
+							if s, ok := pobj["b64_json"].(string); ok && s != "" {
+								return base64.StdEncoding.DecodeString(s)
+							}
+						}
+					}
+				}
+				// some providers embed a single data URL string
+				if s, ok := msg["content"].(string); ok && strings.HasPrefix(s, "data:") {
+					if i := strings.IndexByte(s, ','); i > 0 {
+						return base64.StdEncoding.DecodeString(s[i+1:])
+					}
+				}
+			}
+		}
+	}
+	return nil, ErrNoImage
+}
+
+func parseTextFromChatJSON(m map[string]any) (string, error) {
+	// OpenAI chat completions
+	if choices, ok := m["choices"].([]any); ok && len(choices) > 0 {
+		ch, _ := choices[0].(map[string]any)
+		if ch != nil {
+			if msg, ok := ch["message"].(map[string]any); ok {
+				if s, ok := msg["content"].(string); ok && strings.TrimSpace(s) != "" {
+					return s, nil
+				}
+				if parts, ok := msg["content"].([]any); ok {
+					var sb strings.Builder
+					for _, p := range parts {
+						pobj, _ := p.(map[string]any)
+						if pobj == nil {
+							continue
+						}
+						if t, _ := pobj["type"].(string); t == "text" || t == "output_text" {
+							if s, _ := pobj["text"].(string); s != "" {
+								sb.WriteString(s)
+							}
+						}
+					}
+					if strings.TrimSpace(sb.String()) != "" {
+						return sb.String(), nil
+					}
+				}
+			}
+		}
+	}
+	// Responses API
+	if outArr, ok := m["output"].([]any); ok {
+		var sb strings.Builder
+		for _, item := range outArr {
+			obj, _ := item.(map[string]any)
+			if obj == nil {
+				continue
+			}
+			contentArr, _ := obj["content"].([]any)
+			for _, c := range contentArr {
+				cobj, _ := c.(map[string]any)
+				if cobj == nil {
+					continue
+				}
+				if t, _ := cobj["type"].(string); t == "output_text" || t == "text" {
+					if s, _ := cobj["text"].(string); s != "" {
+						sb.WriteString(s)
+					}
+				}
+			}
+		}
+		if strings.TrimSpace(sb.String()) != "" {
+			return sb.String(), nil
+		}
+	}
+	return "", noTextError(openRouterProviderName, openRouterResponseInfo(m, ""))
+}
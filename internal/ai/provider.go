@@ -0,0 +1,215 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ============================
+// Provider abstraction
+// ============================
+
+// defaultProviderName is used when the model string carries no registered prefix.
+const defaultProviderName = "gemini"
+
+// Part is a provider-neutral piece of a user message: either text or an inline image.
+type Part struct {
+	Text     string
+	MIMEType string
+	Data     []byte
+}
+
+// TextPart returns a text-only Part.
+func TextPart(s string) Part { return Part{Text: s} }
+
+// ImagePart returns an inline image Part.
+func ImagePart(mime string, data []byte) Part { return Part{MIMEType: mime, Data: data} }
+
+// IsImage reports whether the part carries image data.
+func (p Part) IsImage() bool { return len(p.Data) > 0 }
+
+// GenerationConfig carries optional generation settings for an image thread or a
+// critique. Providers keep a thread's config in its session so every turn is
+// generated with the same settings.
+type GenerationConfig struct {
+	AspectRatio string `json:"aspect_ratio,omitempty"`
+	Resolution  string `json:"resolution,omitempty"`
+	// SystemInstruction is sent as the system prompt of every request.
+	SystemInstruction string   `json:"system_instruction,omitempty"`
+	Temperature       *float32 `json:"temperature,omitempty"`
+	TopP              *float32 `json:"top_p,omitempty"`
+	Seed              *int32   `json:"seed,omitempty"`
+	// CandidateCount asks for several candidates per image request; the first
+	// one holding an image is used.
+	CandidateCount int32           `json:"candidate_count,omitempty"`
+	SafetySettings []SafetySetting `json:"safety_settings,omitempty"`
+}
+
+// IsZero reports whether no generation settings were requested.
+func (c GenerationConfig) IsZero() bool {
+	return !c.hasImageConfig() && !c.hasSampling() && c.SystemInstruction == "" && c.CandidateCount == 0 && len(c.SafetySettings) == 0
+}
+
+func (c GenerationConfig) hasImageConfig() bool { return c.AspectRatio != "" || c.Resolution != "" }
+
+func (c GenerationConfig) hasSampling() bool {
+	return c.Temperature != nil || c.TopP != nil || c.Seed != nil
+}
+
+// Capabilities describes optional features a provider supports for a model.
+type Capabilities struct {
+	// ImageConfig reports whether aspect ratio and resolution can be requested.
+	ImageConfig bool
+	// Sampling reports whether temperature, top-p and seed can be set.
+	Sampling bool
+	// SystemInstruction reports whether a system instruction can be sent.
+	SystemInstruction bool
+	// SafetySettings reports whether safety thresholds can be set.
+	SafetySettings bool
+	// MaxCandidates is the most candidates one request may ask for; 0 means 1.
+	MaxCandidates int
+}
+
+// Session is the provider-specific conversation state carried by an ImageThread.
+// Providers type-assert it back to their own concrete type. Sessions must encode
+// with encoding/json so threads can be saved and resumed.
+type Session any
+
+// Provider is a backend capable of threaded image generation and critique.
+// Implementations are registered by name with RegisterProvider and selected via
+// the "<name>/" model prefix.
+type Provider interface {
+	// Capabilities reports the optional features available for model.
+	Capabilities(model string) Capabilities
+	// StartThread opens a new session whose first user message is parts and
+	// generates the first image. It returns the session, the image bytes and any
+	// assistant text.
+	StartThread(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (Session, []byte, string, error)
+	// ContinueThread appends a user message to s and generates the next image.
+	// The session is only extended when generation succeeds.
+	ContinueThread(ctx context.Context, s Session, parts []Part) ([]byte, string, error)
+	// Critique returns critique text for a single user message, sent with cfg's
+	// system instruction, sampling and safety settings.
+	Critique(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (string, error)
+	// ForkSession returns an independent copy of s holding only its first n
+	// successful turns, where n is turns. It must not modify s.
+	ForkSession(s Session, turns int) (Session, error)
+	// DecodeSession restores a session previously encoded with encoding/json.
+	DecodeSession(data []byte) (Session, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]Provider{}
+)
+
+// RegisterProvider makes p available under name. Models prefixed with "<name>/"
+// are routed to it. Registering the same name twice replaces the earlier provider.
+func RegisterProvider(name string, p Provider) {
+	name = strings.TrimSpace(name)
+	if name == "" || p == nil {
+		panic("ai: RegisterProvider requires a name and a provider")
+	}
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = p
+}
+
+// Providers returns the sorted names of all registered providers.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for n := range providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupProvider returns the provider registered as name, wrapped so that its
+// calls are retried according to the retry policy.
+func lookupProvider(name string) (Provider, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, false
+	}
+	return retryingProvider{Provider: p, name: name}, true
+}
+
+// resolveModelProvider determines the effective model name and the provider it routes to.
+// It supports legacy env vars (USE_OPENROUTER) and the "<provider>/" model prefix convention.
+func resolveModelProvider(model string) (string, string) {
+	// Prefix convention: "<provider>/<model>" for any registered provider. An
+	// explicit prefix wins over the legacy environment so generation and critique
+	// can be routed to different providers.
+	if i := strings.IndexByte(model, '/'); i > 0 {
+		if _, ok := lookupProvider(model[:i]); ok {
+			return model[i+1:], model[:i]
+		}
+	}
+
+	// Legacy USE_OPENROUTER
+	if v := strings.TrimSpace(os.Getenv("USE_OPENROUTER")); v == "1" || strings.EqualFold(v, "true") {
+		legacyWarning.Do(func() {
+			fmt.Fprintln(os.Stderr, "NOTE: USE_OPENROUTER is deprecated. Please set MODEL=openrouter/<model> instead.")
+		})
+		// Legacy: allow OPENROUTER_MODEL override
+		if env := strings.TrimSpace(os.Getenv("OPENROUTER_MODEL")); env != "" {
+			return env, openRouterProviderName
+		}
+		return model, openRouterProviderName
+	}
+
+	return model, defaultProviderName
+}
+
+// ModelCapabilities reports the optional features available for model.
+func ModelCapabilities(model string) (Capabilities, error) {
+	p, _, effModel, err := providerForModel(model)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return p.Capabilities(effModel), nil
+}
+
+// providerForModel resolves model to its provider, the provider's registered name
+// and the effective model name.
+func providerForModel(model string) (Provider, string, string, error) {
+	loadEnvIfMissing()
+	effModel, name := resolveModelProvider(model)
+	p, ok := lookupProvider(name)
+	if !ok {
+		return nil, "", "", fmt.Errorf("no provider registered for %q", name)
+	}
+	return p, name, effModel, nil
+}
+
+func guessMIME(p string) string {
+	mime := "image/png"
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".jpg", ".jpeg":
+		mime = "image/jpeg"
+	case ".webp":
+		mime = "image/webp"
+	case ".gif":
+		mime = "image/gif"
+	}
+	return mime
+}
+
+// readImagePart loads an image file into an inline Part.
+func readImagePart(path string) (Part, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Part{}, err
+	}
+	return ImagePart(guessMIME(path), b), nil
+}
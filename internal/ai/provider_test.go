@@ -0,0 +1,182 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type stubProvider struct{}
+
+func (stubProvider) Capabilities(string) Capabilities { return Capabilities{} }
+func (stubProvider) StartThread(context.Context, string, []Part, GenerationConfig) (Session, []byte, string, error) {
+	return nil, nil, "", nil
+}
+func (stubProvider) ContinueThread(context.Context, Session, []Part) ([]byte, string, error) {
+	return nil, "", nil
+}
+func (stubProvider) Critique(context.Context, string, []Part, GenerationConfig) (string, error) {
+	return "", nil
+}
+func (stubProvider) ForkSession(Session, int) (Session, error) { return nil, nil }
+func (stubProvider) DecodeSession([]byte) (Session, error)     { return nil, nil }
+
+func TestResolveModelProvider(t *testing.T) {
+	t.Setenv("USE_OPENROUTER", "")
+	RegisterProvider("inhouse", stubProvider{})
+
+	cases := []struct {
+		in, model, provider string
+	}{
+		{"gemini-3-pro-image-preview", "gemini-3-pro-image-preview", "gemini"},
+		{"google/gemini-3-pro-image-preview", "google/gemini-3-pro-image-preview", "gemini"},
+		{"openrouter/google/gemini-3-pro-image-preview", "google/gemini-3-pro-image-preview", "openrouter"},
+		{"inhouse/painter-v2", "painter-v2", "inhouse"},
+	}
+	for _, c := range cases {
+		model, provider := resolveModelProvider(c.in)
+		if model != c.model || provider != c.provider {
+			t.Errorf("resolveModelProvider(%q) = (%q, %q), want (%q, %q)", c.in, model, provider, c.model, c.provider)
+		}
+	}
+
+	// An explicit prefix (e.g. a native critique model) beats the legacy OpenRouter switch.
+	t.Setenv("USE_OPENROUTER", "true")
+	if model, provider := resolveModelProvider("gemini/gemini-2.5-flash"); model != "gemini-2.5-flash" || provider != "gemini" {
+		t.Errorf("prefixed model under USE_OPENROUTER = (%q, %q)", model, provider)
+	}
+	if _, provider := resolveModelProvider("gemini-3-pro-image-preview"); provider != "openrouter" {
+		t.Errorf("unprefixed model under USE_OPENROUTER routed to %q", provider)
+	}
+}
+
+// scriptedCritic returns its responses in order, recording each request.
+type scriptedCritic struct {
+	stubProvider
+	responses []string
+	requests  [][]Part
+}
+
+func (s *scriptedCritic) Critique(_ context.Context, _ string, parts []Part, _ GenerationConfig) (string, error) {
+	s.requests = append(s.requests, parts)
+	r := s.responses[0]
+	s.responses = s.responses[1:]
+	return r, nil
+}
+
+func TestGenerateStructuredCritiqueReasks(t *testing.T) {
+	img := filepath.Join(t.TempDir(), "img.png")
+	png, err := fakeImage(fakeDigest("", "x", nil), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(img, png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	critic := &scriptedCritic{responses: []string{
+		"Looks good overall, but the sky is too dark.",
+		`{"keep_notes": [], "edits": [{"id": "sky", "target": {"type": "region", "label": "sky", "bbox": {"x": 0, "y": 0, "w": 1, "h": 0.4}}, "priority": "MAJOR", "instruction": "Brighten the sky."}]}`,
+	}}
+	RegisterProvider("scripted", critic)
+
+	res, _, err := GenerateStructuredCritique(context.Background(), "scripted/critic", img, "a beach", nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(critic.requests) != 2 || len(res.Edits) != 1 || res.Edits[0].ID != "sky" {
+		t.Fatalf("requests=%d result=%+v", len(critic.requests), res)
+	}
+	last := critic.requests[1][len(critic.requests[1])-1]
+	if !strings.Contains(last.Text, "could not be used") {
+		t.Fatalf("expected a repair instruction, got %q", last.Text)
+	}
+}
+
+func TestGenerationConfigForGemini(t *testing.T) {
+	s, err := ParseSafetySetting("Image-Dangerous=medium")
+	if err != nil || s.Category != "HARM_CATEGORY_IMAGE_DANGEROUS_CONTENT" || s.Threshold != "BLOCK_MEDIUM_AND_ABOVE" {
+		t.Fatalf("ParseSafetySetting = %+v, %v", s, err)
+	}
+	temp, seed := float32(0.3), int32(42)
+	cfg := GenerationConfig{SystemInstruction: "Draw in ink.", Temperature: &temp, Seed: &seed, CandidateCount: 2, SafetySettings: []SafetySetting{s}}
+
+	var g geminiProvider
+	if err := cfg.check("gemini-3-pro-image-preview", g.Capabilities("gemini-3-pro-image-preview")); err == nil || !strings.Contains(err.Error(), "at most 1 candidate") {
+		t.Fatalf("expected a candidate count error, got %v", err)
+	}
+	if err := cfg.check("gemini-2.5-flash", g.Capabilities("gemini-2.5-flash")); err != nil {
+		t.Fatal(err)
+	}
+	gc := geminiConfig(cfg, false)
+	if gc.ResponseModalities != nil || gc.ImageConfig != nil || *gc.Temperature != temp || *gc.Seed != seed || gc.CandidateCount != 2 {
+		t.Fatalf("unexpected config %+v", gc)
+	}
+	if gc.SystemInstruction.Parts[0].Text != "Draw in ink." || len(gc.SafetySettings) != 1 || gc.SafetySettings[0].Threshold != "BLOCK_MEDIUM_AND_ABOVE" {
+		t.Fatalf("unexpected instruction or safety settings %+v", gc)
+	}
+	if geminiConfig(GenerationConfig{}, true) != nil {
+		t.Fatal("an empty config should send no GenerateContentConfig")
+	}
+}
+
+// recordingProvider is the fake provider, recording the parts of each follow-up.
+type recordingProvider struct {
+	fakeProvider
+	requests [][]Part
+}
+
+func (r *recordingProvider) ContinueThread(ctx context.Context, s Session, parts []Part) ([]byte, string, error) {
+	r.requests = append(r.requests, parts)
+	return r.fakeProvider.ContinueThread(ctx, s, parts)
+}
+
+func TestMaskAttachedAfterReferences(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, seed string) string {
+		png, err := fakeImage(fakeDigest("", seed, nil), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, png, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ref, current, maskPath := write("ref.png", "ref"), write("current.png", "current"), write("mask.png", "mask")
+
+	rec := &recordingProvider{}
+	RegisterProvider("recording", rec)
+	thread, _, err := StartImageThreadAndGenerate(context.Background(), "recording/test", []string{ref}, "a lighthouse", nil, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := thread.AddUserMessageWithMask(context.Background(), "fix the sky", []string{current}, maskPath); err != nil {
+		t.Fatal(err)
+	}
+	parts := rec.requests[len(rec.requests)-1]
+	maskBytes, _ := os.ReadFile(maskPath)
+	refBytes, _ := os.ReadFile(ref)
+	n := len(parts)
+	if n != 5 || !bytes.Equal(parts[2].Data, refBytes) || parts[n-2].Text != MaskLabel || !bytes.Equal(parts[n-1].Data, maskBytes) {
+		t.Fatalf("expected prompt, current, reference, label and mask; got %d parts", n)
+	}
+}
+
+func TestFakeImageExtremeAspectRatios(t *testing.T) {
+	for ratio, want := range map[string]image.Point{"1:300": {1, fakeImageBase}, "300:1": {fakeImageBase, 1}, "16:9": {fakeImageBase, 144}} {
+		b, err := fakeImage(fakeDigest("", ratio, nil), ratio)
+		if err != nil {
+			t.Fatalf("%s: %v", ratio, err)
+		}
+		cfg, err := png.DecodeConfig(bytes.NewReader(b))
+		if err != nil || cfg.Width != want.X || cfg.Height != want.Y {
+			t.Fatalf("%s: got %dx%d (%v), want %v", ratio, cfg.Width, cfg.Height, err, want)
+		}
+	}
+}
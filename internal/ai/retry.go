@@ -0,0 +1,344 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// ============================
+// Error classification & retry
+// ============================
+
+// ErrorClass says what kind of failure a provider call hit, which decides
+// whether it is worth retrying.
+type ErrorClass int
+
+const (
+	// ClassPermanent is any failure retrying will not fix (bad request, no image).
+	ClassPermanent ErrorClass = iota
+	// ClassRateLimit is a 429: too many requests right now.
+	ClassRateLimit
+	// ClassQuota is an exhausted quota or credit balance (e.g. OpenRouter 402).
+	ClassQuota
+	// ClassAuth is a missing, invalid or unauthorized API key.
+	ClassAuth
+	// ClassBlocked is a request or response refused by content safety filters.
+	ClassBlocked
+	// ClassTransient is a 5xx, timeout or dropped connection.
+	ClassTransient
+	// ClassOutage is an HTML error page (e.g. a Cloudflare 503) instead of JSON.
+	ClassOutage
+)
+
+var errorClassNames = map[ErrorClass]string{
+	ClassPermanent: "error",
+	ClassRateLimit: "rate limited",
+	ClassQuota:     "quota exceeded",
+	ClassAuth:      "authentication failed",
+	ClassBlocked:   "content blocked",
+	ClassTransient: "transient error",
+	ClassOutage:    "provider outage",
+}
+
+func (c ErrorClass) String() string { return errorClassNames[c] }
+
+// Retryable reports whether a failure of this class may succeed if repeated.
+func (c ErrorClass) Retryable() bool {
+	return c == ClassRateLimit || c == ClassTransient || c == ClassOutage
+}
+
+// ProviderError is a classified failure of a provider request.
+type ProviderError struct {
+	Provider string
+	Class    ErrorClass
+	// Status is the HTTP status code, when there was a response.
+	Status int
+	// RetryAfter is how long the provider asked callers to wait, if it said.
+	RetryAfter time.Duration
+	Message    string
+	// Body is a truncated preview of the response body.
+	Body string
+	Err  error
+}
+
+func (e *ProviderError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", e.Provider, e.Class)
+	if e.Status != 0 {
+		fmt.Fprintf(&b, " (HTTP %d)", e.Status)
+	}
+	switch {
+	case e.Message != "":
+		fmt.Fprintf(&b, ": %s", e.Message)
+	case e.Err != nil:
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+func (e *ProviderError) Unwrap() error { return e.Err }
+
+// bodyPreviewLimit bounds the response body kept in a ProviderError.
+const bodyPreviewLimit = 2048
+
+func previewBody(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if len(s) > bodyPreviewLimit {
+		s = s[:bodyPreviewLimit] + "... [truncated]"
+	}
+	return s
+}
+
+// looksLikeHTML reports whether a body is an HTML page rather than an API response.
+func looksLikeHTML(b []byte) bool {
+	s := strings.ToLower(strings.TrimSpace(string(b)))
+	return strings.HasPrefix(s, "<!doctype html") || strings.HasPrefix(s, "<html") || strings.Contains(s, "<title>")
+}
+
+// classifyStatus maps an HTTP status (and error text) to an ErrorClass. The text
+// only refines the status: a request rejected as 400, 403 or 422 that mentions
+// safety or moderation is blocked, while a 429 or 5xx that happens to mention
+// safety is still retried.
+func classifyStatus(status int, message string) ErrorClass {
+	msg := strings.ToLower(message)
+	rejected := status == http.StatusBadRequest || status == http.StatusForbidden || status == http.StatusUnprocessableEntity
+	switch {
+	case rejected && (strings.Contains(msg, "safety") || strings.Contains(msg, "moderation") || strings.Contains(msg, "flagged")):
+		return ClassBlocked
+	case status == http.StatusPaymentRequired:
+		return ClassQuota
+	case status == http.StatusTooManyRequests:
+		// Gemini reports exhausted daily/billing quotas as 429s too.
+		if strings.Contains(msg, "quota") && (strings.Contains(msg, "billing") || strings.Contains(msg, "per day") || strings.Contains(msg, "limit: 0")) {
+			return ClassQuota
+		}
+		return ClassRateLimit
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ClassAuth
+	case status == http.StatusBadRequest && (strings.Contains(msg, "api key") || strings.Contains(msg, "api_key")):
+		return ClassAuth
+	case status == http.StatusRequestTimeout || status >= 500:
+		return ClassTransient
+	}
+	return ClassPermanent
+}
+
+// parseRetryAfter reads a Retry-After header: delay seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// classifyError turns a provider error into a *ProviderError. Errors that are
+// already classified are returned as is; nil means err is not a provider failure
+// (e.g. a local error or a cancelled context).
+func classifyError(provider string, err error) *ProviderError {
+	var pe *ProviderError
+	if errors.As(err, &pe) {
+		return pe
+	}
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		pe := &ProviderError{Provider: provider, Status: apiErr.Code, Message: apiErr.Message, Err: err}
+		if looksLikeHTML([]byte(apiErr.Message)) {
+			pe.Class = ClassOutage
+			pe.Message = "the provider returned an HTML error page instead of an API response"
+			pe.Body = previewBody([]byte(apiErr.Message))
+			return pe
+		}
+		pe.Class = classifyStatus(apiErr.Code, apiErr.Message+" "+apiErr.Status)
+		pe.RetryAfter = geminiRetryDelay(apiErr.Details)
+		return pe
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	var netErr net.Error
+	var opErr *net.OpError
+	timeout := errors.As(err, &netErr) && netErr.Timeout()
+	if timeout || errors.As(err, &opErr) || errors.Is(err, context.DeadlineExceeded) || isConnectionReset(err) {
+		return &ProviderError{Provider: provider, Class: ClassTransient, Err: err}
+	}
+	return nil
+}
+
+// isConnectionReset reports a connection the server dropped: an EOF from the HTTP
+// transport, a reset or a broken pipe. An EOF while decoding a response body is
+// not one; the body was bad, and sending the request again will not fix it.
+func isConnectionReset(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && (errors.Is(urlErr.Err, io.EOF) || errors.Is(urlErr.Err, io.ErrUnexpectedEOF)) {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// geminiRetryDelay reads the google.rpc.RetryInfo detail of a Gemini error.
+func geminiRetryDelay(details []map[string]any) time.Duration {
+	for _, d := range details {
+		if t, _ := d["@type"].(string); !strings.HasSuffix(t, "RetryInfo") {
+			continue
+		}
+		if s, _ := d["retryDelay"].(string); s != "" {
+			if dur, err := time.ParseDuration(s); err == nil {
+				return dur
+			}
+		}
+	}
+	return 0
+}
+
+// RetryPolicy bounds how failed provider calls are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, including the first (1 disables retries).
+	MaxAttempts int
+	// Budget caps the total time spent on one call including waits; 0 means no cap.
+	Budget time.Duration
+	// BaseDelay is the first backoff; each retry doubles it up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryPolicy is used until SetRetryPolicy is called.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, Budget: 3 * time.Minute, BaseDelay: 2 * time.Second, MaxDelay: 45 * time.Second}
+
+var (
+	retryMu     sync.Mutex
+	retryPolicy = DefaultRetryPolicy
+	// retryLog receives a line for every retry.
+	retryLog io.Writer = os.Stderr
+	// sleep waits for d or until ctx is done; tests replace it.
+	sleep = func(ctx context.Context, d time.Duration) error {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			return nil
+		}
+	}
+)
+
+// SetRetryPolicy sets how provider calls are retried. Zero fields keep their
+// DefaultRetryPolicy values.
+func SetRetryPolicy(p RetryPolicy) {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	retryMu.Lock()
+	retryPolicy = p
+	retryMu.Unlock()
+}
+
+func currentRetryPolicy() RetryPolicy {
+	retryMu.Lock()
+	defer retryMu.Unlock()
+	return retryPolicy
+}
+
+// backoff returns the wait before retry n (1-based): exponential with full jitter,
+// but never shorter than what the provider asked for.
+func (p RetryPolicy) backoff(n int, retryAfter time.Duration) time.Duration {
+	d := p.BaseDelay << (n - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	d = d/2 + rand.N(d/2+1)
+	return max(d, retryAfter)
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// retryable, or the policy's attempts or time budget run out. Provider failures
+// are returned as *ProviderError; other errors are returned unchanged.
+func withRetry[T any](ctx context.Context, provider, op string, fn func() (T, error)) (T, error) {
+	p := currentRetryPolicy()
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		v, err := fn()
+		if err == nil {
+			return v, nil
+		}
+		pe := classifyError(provider, err)
+		if pe == nil {
+			return v, err
+		}
+		if !pe.Class.Retryable() || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return v, pe
+		}
+		wait := p.backoff(attempt, pe.RetryAfter)
+		if p.Budget > 0 && time.Since(start)+wait > p.Budget {
+			return v, pe
+		}
+		fmt.Fprintf(retryLog, "%s %s: %s; retrying in %s (attempt %d of %d)\n", provider, op, pe.Class, wait.Round(100*time.Millisecond), attempt+1, p.MaxAttempts)
+		if err := sleep(ctx, wait); err != nil {
+			return v, pe
+		}
+	}
+}
+
+// retryingProvider retries the network calls of a Provider with withRetry.
+type retryingProvider struct {
+	Provider
+	name string
+}
+
+// generated is an image call's results, so withRetry can return them as one value.
+type generated struct {
+	session Session
+	img     []byte
+	text    string
+}
+
+func (r retryingProvider) StartThread(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (Session, []byte, string, error) {
+	g, err := withRetry(ctx, r.name, "generation", func() (generated, error) {
+		s, img, text, err := r.Provider.StartThread(ctx, model, parts, cfg)
+		return generated{s, img, text}, err
+	})
+	return g.session, g.img, g.text, err
+}
+
+func (r retryingProvider) ContinueThread(ctx context.Context, s Session, parts []Part) ([]byte, string, error) {
+	g, err := withRetry(ctx, r.name, "generation", func() (generated, error) {
+		img, text, err := r.Provider.ContinueThread(ctx, s, parts)
+		return generated{img: img, text: text}, err
+	})
+	return g.img, g.text, err
+}
+
+func (r retryingProvider) Critique(ctx context.Context, model string, parts []Part, cfg GenerationConfig) (string, error) {
+	return withRetry(ctx, r.name, "critique", func() (string, error) {
+		return r.Provider.Critique(ctx, model, parts, cfg)
+	})
+}
@@ -0,0 +1,113 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// flakyCritic fails with errs in order, then succeeds.
+type flakyCritic struct {
+	stubProvider
+	errs  []error
+	calls int
+}
+
+func (f *flakyCritic) Critique(context.Context, string, []Part, GenerationConfig) (string, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return "", err
+	}
+	return "ok", nil
+}
+
+func stubSleep(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	prevSleep, prevLog := sleep, retryLog
+	sleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	retryLog = io.Discard
+	t.Cleanup(func() {
+		sleep, retryLog = prevSleep, prevLog
+		SetRetryPolicy(DefaultRetryPolicy)
+	})
+	return &waits
+}
+
+func TestRetryHonorsRetryAfterAndStopsOnPermanentErrors(t *testing.T) {
+	waits := stubSleep(t)
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond})
+
+	critic := &flakyCritic{errs: []error{
+		genai.APIError{Code: 429, Message: "Resource exhausted", Details: []map[string]any{{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "7s"}}},
+		&ProviderError{Provider: "flaky", Class: ClassOutage, Status: 503},
+	}}
+	RegisterProvider("flaky", critic)
+	p, _ := lookupProvider("flaky")
+	if text, err := p.Critique(context.Background(), "m", nil, GenerationConfig{}); err != nil || text != "ok" {
+		t.Fatalf("Critique = %q, %v", text, err)
+	}
+	if critic.calls != 3 || len(*waits) != 2 || (*waits)[0] != 7*time.Second || (*waits)[1] > 10*time.Millisecond {
+		t.Fatalf("calls=%d waits=%v", critic.calls, *waits)
+	}
+
+	// Auth failures are not retried.
+	critic.calls, critic.errs = 0, []error{genai.APIError{Code: 400, Message: "API key not valid. Please pass a valid API key."}}
+	_, err := p.Critique(context.Background(), "m", nil, GenerationConfig{})
+	var pe *ProviderError
+	if !errors.As(err, &pe) || pe.Class != ClassAuth || critic.calls != 1 {
+		t.Fatalf("expected one auth failure, got %v after %d calls", err, critic.calls)
+	}
+
+	// Attempts are capped.
+	dropped := &url.Error{Op: "Post", URL: "https://example.test", Err: io.EOF}
+	critic.calls, critic.errs = 0, []error{dropped, dropped, dropped, dropped, dropped}
+	if _, err := p.Critique(context.Background(), "m", nil, GenerationConfig{}); !errors.As(err, &pe) || pe.Class != ClassTransient || critic.calls != 4 {
+		t.Fatalf("expected 4 transient attempts, got %v after %d calls", err, critic.calls)
+	}
+
+	// A body that fails to decode is not retried.
+	critic.calls, critic.errs = 0, []error{fmt.Errorf("decode response: %w", io.ErrUnexpectedEOF)}
+	if _, err := p.Critique(context.Background(), "m", nil, GenerationConfig{}); err == nil || errors.As(err, &pe) || critic.calls != 1 {
+		t.Fatalf("expected one unclassified failure, got %v after %d calls", err, critic.calls)
+	}
+}
+
+func TestClassifyStatus(t *testing.T) {
+	cases := []struct {
+		status int
+		msg    string
+		want   ErrorClass
+	}{
+		{http.StatusTooManyRequests, "Rate limit exceeded", ClassRateLimit},
+		{http.StatusTooManyRequests, "You exceeded your current quota, please check your plan and billing details", ClassQuota},
+		{http.StatusPaymentRequired, "Insufficient credits", ClassQuota},
+		{http.StatusUnauthorized, "No auth credentials found", ClassAuth},
+		{http.StatusForbidden, "Your input was flagged by moderation", ClassBlocked},
+		{http.StatusBadRequest, "Request blocked by safety filters", ClassBlocked},
+		{http.StatusServiceUnavailable, "overloaded", ClassTransient},
+		{http.StatusServiceUnavailable, "safety service unavailable", ClassTransient},
+		{http.StatusTooManyRequests, "Too many requests to the moderation endpoint", ClassRateLimit},
+		{http.StatusBadRequest, "invalid aspect ratio", ClassPermanent},
+	}
+	for _, c := range cases {
+		if got := classifyStatus(c.status, c.msg); got != c.want {
+			t.Errorf("classifyStatus(%d, %q) = %v, want %v", c.status, c.msg, got, c.want)
+		}
+	}
+	if d := parseRetryAfter("12"); d != 12*time.Second {
+		t.Errorf("parseRetryAfter(12) = %v", d)
+	}
+}
@@ -0,0 +1,184 @@
+package ai
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ============================
+// Session persistence
+// ============================
+
+const (
+	sessionFileName = "session.json"
+	sessionVersion  = 1
+)
+
+// savedThread is the on-disk form of an ImageThread (session.json).
+type savedThread struct {
+	Version     int               `json:"version"`
+	Provider    string            `json:"provider"`
+	Model       string            `json:"model"`
+	Config      GenerationConfig  `json:"config"`
+	Prompt      string            `json:"prompt"`
+	Fragments   []string          `json:"fragments,omitempty"`
+	Vars        map[string]string `json:"vars,omitempty"`
+	InputImages []string          `json:"input_images,omitempty"`
+	Output      string            `json:"output,omitempty"`
+	Branch      string            `json:"branch,omitempty"`
+	Turns       []*ThreadTurn     `json:"turns"`
+	Branches    []savedBranch     `json:"branches,omitempty"`
+}
+
+// savedBranch is one branch of a saved thread with its own provider state.
+type savedBranch struct {
+	Name  string          `json:"name"`
+	Head  int             `json:"head"`
+	State json.RawMessage `json:"state"`
+}
+
+// Save writes the thread to dir: session.json holds the turn tree, every branch's
+// history, the model, generation config and input paths, and each turn's image is
+// written as turn_NNN.png. Save is meant to be called after every turn so a failed
+// run can be resumed; images already written to dir by an earlier Save (or found
+// there by LoadImageThread) are not written again.
+func (t *ImageThread) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if abs := absPath(dir); abs != t.savedDir {
+		t.savedDir, t.saved = abs, map[*ThreadTurn]bool{}
+	}
+	branches := make([]savedBranch, 0, len(t.branches))
+	for _, name := range t.branchNames() {
+		b := t.branches[name]
+		state, err := json.Marshal(b.session)
+		if err != nil {
+			return fmt.Errorf("encode %s session for branch %s: %w", t.providerName, name, err)
+		}
+		branches = append(branches, savedBranch{Name: name, Head: b.head, State: state})
+	}
+	for _, turn := range t.nodes {
+		if turn.Image == "" {
+			turn.Image = fmt.Sprintf("turn_%03d.png", turn.ID)
+		}
+		if len(turn.image) == 0 || t.saved[turn] {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, turn.Image), turn.image, 0o644); err != nil {
+			return err
+		}
+		t.saved[turn] = true
+	}
+	st := savedThread{
+		Version:     sessionVersion,
+		Provider:    t.providerName,
+		Model:       t.modelRef,
+		Config:      t.config,
+		Prompt:      t.prompt,
+		Fragments:   absPaths(t.fragments),
+		Vars:        t.vars,
+		InputImages: absPaths(t.originalInputImagePaths),
+		Output:      absPath(t.output),
+		Branch:      t.branch,
+		Turns:       t.nodes,
+		Branches:    branches,
+	}
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write atomically so a crash mid-save never corrupts an existing session.
+	tmp := filepath.Join(dir, sessionFileName+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, sessionFileName))
+}
+
+// LoadImageThread restores a thread saved with Save so it can be continued.
+func LoadImageThread(dir string) (*ImageThread, error) {
+	b, err := os.ReadFile(filepath.Join(dir, sessionFileName))
+	if err != nil {
+		return nil, err
+	}
+	var st savedThread
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("invalid session %s: %w", dir, err)
+	}
+	if st.Version != sessionVersion {
+		return nil, fmt.Errorf("unsupported session version %d in %s", st.Version, dir)
+	}
+	loadEnvIfMissing()
+	p, ok := lookupProvider(st.Provider)
+	if !ok {
+		return nil, fmt.Errorf("session %s uses unknown provider %q", dir, st.Provider)
+	}
+	if len(st.Branches) == 0 || len(st.Turns) == 0 {
+		return nil, errors.New("session has no turns")
+	}
+	effModel, _ := resolveModelProvider(st.Model)
+	t := &ImageThread{
+		provider:                p,
+		providerName:            st.Provider,
+		modelRef:                st.Model,
+		model:                   effModel,
+		config:                  st.Config,
+		prompt:                  st.Prompt,
+		fragments:               st.Fragments,
+		vars:                    st.Vars,
+		originalInputImagePaths: st.InputImages,
+		output:                  st.Output,
+		nodes:                   st.Turns,
+		branches:                map[string]*threadBranch{},
+		branch:                  st.Branch,
+		savedDir:                absPath(dir),
+		saved:                   map[*ThreadTurn]bool{},
+	}
+	for _, sb := range st.Branches {
+		if t.node(sb.Head) == nil {
+			return nil, fmt.Errorf("branch %s points at missing turn %d", sb.Name, sb.Head)
+		}
+		session, err := p.DecodeSession(sb.State)
+		if err != nil {
+			return nil, err
+		}
+		t.branches[sb.Name] = &threadBranch{head: sb.Head, session: session}
+	}
+	if _, ok := t.branches[t.branch]; !ok {
+		t.branch = st.Branches[0].Name
+	}
+	for _, turn := range t.nodes {
+		if turn.Image == "" {
+			continue
+		}
+		img, err := os.ReadFile(filepath.Join(dir, turn.Image))
+		if err != nil {
+			return nil, err
+		}
+		turn.image = img
+		t.saved[turn] = true
+	}
+	return t, nil
+}
+
+func absPaths(paths []string) []string {
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		out = append(out, absPath(p))
+	}
+	return out
+}
+
+func absPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,149 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAndResumeThread(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	thread, _, err := StartImageThreadAndGenerate(ctx, "fake/test", nil, "a red fox", nil, nil, "1:1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := thread.AddUserMessageAndGenerate(ctx, "add snow", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := LoadImageThread(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Model() != "fake/test" || resumed.Prompt() != "a red fox" || len(resumed.Turns()) != 2 {
+		t.Fatalf("unexpected resumed thread: model=%q prompt=%q turns=%d", resumed.Model(), resumed.Prompt(), len(resumed.Turns()))
+	}
+	if !bytes.Equal(resumed.LastImage(), thread.LastImage()) {
+		t.Fatal("expected the last image to be restored")
+	}
+
+	want, err := thread.AddUserMessageAndGenerate(ctx, "make it night", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := resumed.AddUserMessageAndGenerate(ctx, "make it night", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("resumed thread diverged from the original conversation")
+	}
+}
+
+func TestForkCheckoutAndResumeBranches(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	thread, _, err := StartImageThreadAndGenerate(ctx, "fake/test", nil, "a red fox", nil, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := thread.AddUserMessageAndGenerate(ctx, "add snow", ""); err != nil {
+		t.Fatal(err)
+	}
+	snowy := thread.LastImage()
+	if err := thread.Fork("rain", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := thread.AddUserMessageAndGenerate(ctx, "add rain", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := thread.Head(); got.ID != 3 || got.Parent != 1 {
+		t.Fatalf("rain turn = %+v, want id 3 with parent 1", got)
+	}
+	if err := thread.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := LoadImageThread(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Branch() != "rain" || len(resumed.Branches()) != 2 {
+		t.Fatalf("branch=%q branches=%v", resumed.Branch(), resumed.Branches())
+	}
+	if err := resumed.Checkout(DefaultBranch); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resumed.LastImage(), snowy) {
+		t.Fatal("expected main to still end at the snow turn")
+	}
+	if err := resumed.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Head().ID != 1 || resumed.node(2) != nil {
+		t.Fatalf("undo should drop turn 2 from main, head=%d", resumed.Head().ID)
+	}
+	if err := resumed.Undo(); err == nil {
+		t.Fatal("expected undoing the initial generation to fail")
+	}
+}
+
+func TestSaveWritesOnlyNewImages(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	first := filepath.Join(dir, "turn_001.png")
+
+	// A stale image from an earlier session in the same directory is replaced.
+	if err := os.WriteFile(first, []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	thread, img, err := StartImageThreadAndGenerate(ctx, "fake/test", nil, "a red fox", nil, nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(first); !bytes.Equal(b, img) {
+		t.Fatal("expected the stale turn image to be overwritten")
+	}
+
+	// Later saves leave images they already wrote alone.
+	if err := os.WriteFile(first, []byte("untouched"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := thread.AddUserMessageAndGenerate(ctx, "add snow", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := thread.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(first); string(b) != "untouched" {
+		t.Fatal("expected turn 1 not to be rewritten")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "turn_002.png")); !bytes.Equal(b, thread.LastImage()) {
+		t.Fatal("expected turn 2 to be written")
+	}
+
+	// A replaced image is written again, as is everything when saving elsewhere.
+	thread.ReplaceLastImage([]byte("composited"))
+	if err := thread.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "turn_002.png")); string(b) != "composited" {
+		t.Fatal("expected the replaced image to be rewritten")
+	}
+	other := t.TempDir()
+	if err := thread.Save(other); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(other, "turn_001.png")); !bytes.Equal(b, img) {
+		t.Fatal("expected every image in a new directory")
+	}
+}
@@ -0,0 +1,136 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"google.golang.org/genai"
+)
+
+// SafetySetting is a blocking threshold for one harm category, using Gemini's
+// names (e.g. HARM_CATEGORY_HARASSMENT and BLOCK_ONLY_HIGH).
+type SafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+var harmCategories = map[string]genai.HarmCategory{
+	"HARASSMENT":              genai.HarmCategoryHarassment,
+	"HATE":                    genai.HarmCategoryHateSpeech,
+	"HATE_SPEECH":             genai.HarmCategoryHateSpeech,
+	"SEXUAL":                  genai.HarmCategorySexuallyExplicit,
+	"SEXUALLY_EXPLICIT":       genai.HarmCategorySexuallyExplicit,
+	"DANGEROUS":               genai.HarmCategoryDangerousContent,
+	"DANGEROUS_CONTENT":       genai.HarmCategoryDangerousContent,
+	"CIVIC":                   genai.HarmCategoryCivicIntegrity,
+	"CIVIC_INTEGRITY":         genai.HarmCategoryCivicIntegrity,
+	"IMAGE_HATE":              genai.HarmCategoryImageHate,
+	"IMAGE_HARASSMENT":        genai.HarmCategoryImageHarassment,
+	"IMAGE_SEXUAL":            genai.HarmCategoryImageSexuallyExplicit,
+	"IMAGE_SEXUALLY_EXPLICIT": genai.HarmCategoryImageSexuallyExplicit,
+	"IMAGE_DANGEROUS":         genai.HarmCategoryImageDangerousContent,
+	"IMAGE_DANGEROUS_CONTENT": genai.HarmCategoryImageDangerousContent,
+}
+
+var harmThresholds = map[string]genai.HarmBlockThreshold{
+	"NONE":                   genai.HarmBlockThresholdBlockNone,
+	"BLOCK_NONE":             genai.HarmBlockThresholdBlockNone,
+	"LOW":                    genai.HarmBlockThresholdBlockLowAndAbove,
+	"BLOCK_LOW_AND_ABOVE":    genai.HarmBlockThresholdBlockLowAndAbove,
+	"MEDIUM":                 genai.HarmBlockThresholdBlockMediumAndAbove,
+	"BLOCK_MEDIUM_AND_ABOVE": genai.HarmBlockThresholdBlockMediumAndAbove,
+	"HIGH":                   genai.HarmBlockThresholdBlockOnlyHigh,
+	"ONLY_HIGH":              genai.HarmBlockThresholdBlockOnlyHigh,
+	"BLOCK_ONLY_HIGH":        genai.HarmBlockThresholdBlockOnlyHigh,
+	"OFF":                    genai.HarmBlockThresholdOff,
+}
+
+// ParseSafetySetting parses "category=threshold", accepting Gemini's names or
+// short forms in any case: "harassment=high" is HARM_CATEGORY_HARASSMENT at
+// BLOCK_ONLY_HIGH. Categories are harassment, hate, sexual, dangerous, civic and
+// their image_ variants; thresholds are none, low, medium, high and off.
+func ParseSafetySetting(s string) (SafetySetting, error) {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok {
+		return SafetySetting{}, fmt.Errorf("invalid safety setting %q: expected category=threshold", s)
+	}
+	key := func(s string) string {
+		return strings.ReplaceAll(strings.ToUpper(strings.TrimSpace(s)), "-", "_")
+	}
+	category, ok := harmCategories[strings.TrimPrefix(key(k), "HARM_CATEGORY_")]
+	if !ok {
+		return SafetySetting{}, fmt.Errorf("unknown safety category %q (want harassment, hate, sexual, dangerous, civic or an image_ variant)", k)
+	}
+	threshold, ok := harmThresholds[key(v)]
+	if !ok {
+		return SafetySetting{}, fmt.Errorf("unknown safety threshold %q (want none, low, medium, high or off)", v)
+	}
+	return SafetySetting{Category: string(category), Threshold: string(threshold)}, nil
+}
+
+// Validate checks that the settings are in range, independent of any model.
+func (c GenerationConfig) Validate() error {
+	if c.Temperature != nil && (*c.Temperature < 0 || *c.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %g", *c.Temperature)
+	}
+	if c.TopP != nil && (*c.TopP <= 0 || *c.TopP > 1) {
+		return fmt.Errorf("top-p must be greater than 0 and at most 1, got %g", *c.TopP)
+	}
+	if c.CandidateCount < 0 {
+		return fmt.Errorf("candidate count must be at least 1, got %d", c.CandidateCount)
+	}
+	for _, s := range c.SafetySettings {
+		if _, err := ParseSafetySetting(s.Category + "=" + s.Threshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check returns an error naming the first setting in c that model, with caps,
+// cannot honor.
+func (c GenerationConfig) check(model string, caps Capabilities) error {
+	switch {
+	case c.hasImageConfig() && !caps.ImageConfig:
+		return fmt.Errorf("aspect-ratio and resolution are not supported by model %q; they require a native Google Gemini 3 model", model)
+	case c.hasSampling() && !caps.Sampling:
+		return fmt.Errorf("temperature, top-p and seed are not supported by model %q", model)
+	case c.SystemInstruction != "" && !caps.SystemInstruction:
+		return fmt.Errorf("a system instruction is not supported by model %q", model)
+	case len(c.SafetySettings) > 0 && !caps.SafetySettings:
+		return fmt.Errorf("safety settings are not supported by model %q; they require a native Google Gemini model", model)
+	case c.CandidateCount > 1 && int(c.CandidateCount) > max(caps.MaxCandidates, 1):
+		return fmt.Errorf("model %q returns at most %d candidate(s) per request, not %d", model, max(caps.MaxCandidates, 1), c.CandidateCount)
+	}
+	return nil
+}
+
+var (
+	defaultsMu     sync.Mutex
+	defaultsConfig GenerationConfig
+)
+
+// SetGenerationDefaults sets the system instruction, sampling, candidate count
+// and safety settings sent with every new thread and, except for the candidate
+// count, with every critique. Aspect ratio and resolution are chosen per run and
+// are ignored here.
+func SetGenerationDefaults(c GenerationConfig) {
+	c.AspectRatio, c.Resolution = "", ""
+	defaultsMu.Lock()
+	defaultsConfig = c
+	defaultsMu.Unlock()
+}
+
+func generationDefaults() GenerationConfig {
+	defaultsMu.Lock()
+	defer defaultsMu.Unlock()
+	return defaultsConfig
+}
+
+// critiqueConfig is the config sent with critique requests.
+func critiqueConfig() GenerationConfig {
+	c := generationDefaults()
+	c.CandidateCount = 0
+	return c
+}
@@ -0,0 +1,341 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rkirkendall/nano-agent/internal/critique"
+	"github.com/rkirkendall/nano-agent/internal/generate"
+)
+
+// GenerateImage routes to the model's provider to produce an image from an optional
+// set of input images plus a text prompt and fragments. Returns PNG bytes on success.
+// Note: This is a convenience wrapper around StartImageThreadAndGenerate.
+func GenerateImage(ctx context.Context, model string, imagePaths []string, prompt string, fragments []string, vars map[string]string, aspectRatio string, resolution string) ([]byte, error) {
+	_, img, err := StartImageThreadAndGenerate(ctx, model, imagePaths, prompt, fragments, vars, aspectRatio, resolution)
+	return img, err
+}
+
+// critiqueReasks is how many times a critique that fails critique.Parse is sent
+// back to the model for correction.
+const critiqueReasks = 2
+
+// GenerateCritique produces actionable critique text for a given image using the
+// model's provider. It includes the original prompt and optional input reference images;
+// fragments are rendered with vars.
+func GenerateCritique(ctx context.Context, model string, imagePath string, originalPrompt string, fragments []string, vars map[string]string, inputImagePaths []string) (string, error) {
+	parts, err := critiqueRequest(imagePath, originalPrompt, fragments, vars, inputImagePaths)
+	if err != nil {
+		return "", err
+	}
+	return critiqueWithFallback(ctx, model, parts)
+}
+
+// GenerateStructuredCritique is GenerateCritique followed by critique.Parse. When
+// the response is not valid against the schema the model is shown its response and
+// the problems and asked again, up to critiqueReasks times. The raw text of the last
+// response is always returned; the error wraps critique.ErrInvalid when no valid
+// critique was produced.
+func GenerateStructuredCritique(ctx context.Context, model string, imagePath string, originalPrompt string, fragments []string, vars map[string]string, inputImagePaths []string) (*critique.Result, string, error) {
+	parts, err := critiqueRequest(imagePath, originalPrompt, fragments, vars, inputImagePaths)
+	if err != nil {
+		return nil, "", err
+	}
+	var text string
+	for attempt := 0; ; attempt++ {
+		text, err = critiqueWithFallback(ctx, model, parts)
+		if err != nil {
+			return nil, text, err
+		}
+		res, perr := critique.Parse(text)
+		if perr == nil {
+			return res, text, nil
+		}
+		if attempt == critiqueReasks {
+			return nil, text, perr
+		}
+		parts = append(parts[:len(parts):len(parts)], TextPart(critique.BuildRepairInstruction(text, perr)))
+	}
+}
+
+// critiqueWithFallback sends a critique request to model, moving on to the
+// configured fallback models while the failure is transient.
+func critiqueWithFallback(ctx context.Context, model string, parts []Part) (string, error) {
+	var text string
+	cfg := critiqueConfig()
+	_, err := walkRoutes(model, "critique", cfg, func(p Provider, effModel string) error {
+		var err error
+		text, err = p.Critique(ctx, effModel, parts, cfg)
+		return err
+	})
+	return text, err
+}
+
+// critiqueRequest builds the parts of a critique request.
+func critiqueRequest(imagePath string, originalPrompt string, fragments []string, vars map[string]string, inputImagePaths []string) ([]Part, error) {
+	parts := []Part{TextPart(critique.BuildCritiqueInstruction())}
+	if s := strings.TrimSpace(originalPrompt); s != "" {
+		parts = append(parts, TextPart(fmt.Sprintf("Original prompt:\n%s", s)))
+	}
+	img, err := readImagePart(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	parts = append(parts, img)
+	// Attach original input images for context, if provided
+	if len(inputImagePaths) > 0 {
+		parts = append(parts, TextPart("Original input images for reference:"))
+		for _, pth := range inputImagePaths {
+			in, err := readImagePart(pth)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, in)
+		}
+	}
+	frags, err := generate.LoadFragments(fragments, vars)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range frags {
+		parts = append(parts, TextPart(f))
+	}
+	return parts, nil
+}
+
+// ============================
+// Threaded image generation
+// ============================
+
+// ImageThread maintains a conversation history for iterative image generation so
+// that critique prompts can be appended to the original generation thread. Turns
+// form a tree: each named branch points at a head turn and owns the
+// provider-specific Session holding the history from the root to that head.
+type ImageThread struct {
+	provider                Provider
+	providerName            string
+	modelRef                string
+	model                   string
+	config                  GenerationConfig
+	prompt                  string
+	fragments               []string
+	vars                    map[string]string
+	originalInputImagePaths []string
+	output                  string
+	nodes                   []*ThreadTurn
+	branches                map[string]*threadBranch
+	branch                  string
+	// savedDir is the session directory last saved to or loaded from, and saved
+	// holds the turns whose images are already written there.
+	savedDir string
+	saved    map[*ThreadTurn]bool
+}
+
+// ThreadTurn records one successful generation in a thread.
+type ThreadTurn struct {
+	// ID identifies the turn within the thread; the first generation is turn 1.
+	ID int `json:"id"`
+	// Parent is the ID of the turn this one continued from, or 0 for the first turn.
+	Parent int `json:"parent,omitempty"`
+	// Prompt is the user text sent for this turn.
+	Prompt string `json:"prompt"`
+	// Image is the file name of the turn's image within a saved session directory.
+	Image string `json:"image,omitempty"`
+	// Text is any text the model returned alongside the image.
+	Text string `json:"text,omitempty"`
+	// Rating is the critique score of the turn's image, once it has been critiqued.
+	Rating *critique.Rating `json:"rating,omitempty"`
+
+	image []byte
+}
+
+// StartImageThreadAndGenerate creates a new image generation thread with the initial
+// prompt, fragments, and optional input images, generates an image, and returns the
+// thread along with the generated PNG bytes. The prompt and fragments are rendered
+// as templates with vars, which the thread keeps for later turns and critiques.
+func StartImageThreadAndGenerate(ctx context.Context, model string, imagePaths []string, prompt string, fragments []string, vars map[string]string, aspectRatio string, resolution string) (*ImageThread, []byte, error) {
+	cfg := generationDefaults()
+	cfg.AspectRatio, cfg.Resolution = aspectRatio, resolution
+	prompt, err := generate.Render("prompt", prompt, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	frags, err := generate.LoadFragments(fragments, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+	effPrompt := generate.BuildEffectivePrompt(prompt, frags)
+	parts := []Part{TextPart(effPrompt)}
+	for _, pth := range imagePaths {
+		in, err := readImagePart(pth)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, in)
+	}
+
+	var (
+		session Session
+		img     []byte
+		text    string
+	)
+	served, err := walkRoutes(model, "generation", cfg, func(p Provider, effModel string) error {
+		var err error
+		session, img, text, err = p.StartThread(ctx, effModel, parts, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	thread := &ImageThread{
+		provider:                served.provider,
+		providerName:            served.name,
+		modelRef:                served.model,
+		model:                   served.effModel,
+		config:                  cfg,
+		prompt:                  prompt,
+		fragments:               fragments,
+		vars:                    vars,
+		originalInputImagePaths: imagePaths,
+		nodes:                   []*ThreadTurn{{ID: 1, Prompt: effPrompt, Text: text, image: img}},
+		branches:                map[string]*threadBranch{DefaultBranch: {head: 1, session: session}},
+		branch:                  DefaultBranch,
+	}
+	return thread, img, nil
+}
+
+// AddUserMessageAndGenerate appends a new user message to the existing thread using the
+// provided text (e.g., improvement prompt). If imagePath is non-empty, the image is
+// also attached for model reference. Returns the newly generated PNG bytes.
+func (t *ImageThread) AddUserMessageAndGenerate(ctx context.Context, text string, imagePath string) ([]byte, error) {
+	return t.AddUserMessageWithImages(ctx, text, []string{imagePath})
+}
+
+// AddUserMessageWithImages is like AddUserMessageAndGenerate but attaches any number
+// of images (e.g., the current image plus extra references) ahead of the original
+// input images. Unreadable or empty paths are skipped.
+func (t *ImageThread) AddUserMessageWithImages(ctx context.Context, text string, imagePaths []string) ([]byte, error) {
+	return t.AddUserMessageWithMask(ctx, text, imagePaths, "")
+}
+
+// MaskLabel is the text part sent right before an edit mask, so prompts can
+// refer to the mask by name rather than by position.
+const MaskLabel = "Edit mask:"
+
+// AddUserMessageWithMask is AddUserMessageWithImages with an edit mask attached
+// last, after the original input images, and introduced by MaskLabel. An empty
+// maskPath attaches no mask.
+func (t *ImageThread) AddUserMessageWithMask(ctx context.Context, text string, imagePaths []string, maskPath string) ([]byte, error) {
+	parts := []Part{TextPart(text)}
+	for _, pth := range imagePaths {
+		if strings.TrimSpace(pth) == "" {
+			continue
+		}
+		if in, err := readImagePart(pth); err == nil {
+			parts = append(parts, in)
+		}
+	}
+	// Re-attach original input images on every iteration
+	for _, pth := range t.originalInputImagePaths {
+		if strings.TrimSpace(pth) == "" {
+			continue
+		}
+		if in, err := readImagePart(pth); err == nil {
+			parts = append(parts, in)
+		}
+	}
+	if strings.TrimSpace(maskPath) != "" {
+		in, err := readImagePart(maskPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading edit mask: %w", err)
+		}
+		parts = append(parts, TextPart(MaskLabel), in)
+	}
+	b := t.branches[t.branch]
+	img, reply, err := t.provider.ContinueThread(ctx, b.session, parts)
+	if err != nil {
+		return nil, err
+	}
+	turn := &ThreadTurn{ID: t.nextID(), Parent: b.head, Prompt: text, Text: reply, image: img}
+	t.nodes = append(t.nodes, turn)
+	b.head = turn.ID
+	return img, nil
+}
+
+// Model returns the model string of the route that generated the thread's first
+// image, including any provider prefix. It differs from the requested model when
+// a fallback model served the thread.
+func (t *ImageThread) Model() string { return t.modelRef }
+
+// Route names the provider and model serving the thread, e.g.
+// "openrouter/google/gemini-3-pro-image-preview".
+func (t *ImageThread) Route() string { return t.providerName + "/" + t.model }
+
+// Prompt returns the original user prompt of the thread, with its template
+// variables substituted.
+func (t *ImageThread) Prompt() string { return t.prompt }
+
+// Fragments returns the fragment file paths attached to the original prompt.
+func (t *ImageThread) Fragments() []string { return t.fragments }
+
+// Vars returns the template variables the prompt and fragments are rendered with.
+func (t *ImageThread) Vars() map[string]string { return t.vars }
+
+// SetVars replaces the template variables used for later turns and critiques.
+func (t *ImageThread) SetVars(vars map[string]string) { t.vars = vars }
+
+// InputImagePaths returns the original input images re-attached on every turn.
+func (t *ImageThread) InputImagePaths() []string { return t.originalInputImagePaths }
+
+// Output returns the image path the thread's results are written to, if known.
+func (t *ImageThread) Output() string { return t.output }
+
+// SetOutput records the image path the thread's results are written to so a
+// resumed session can default to it.
+func (t *ImageThread) SetOutput(path string) { t.output = path }
+
+// Turns returns the turns of the current branch from the first generation to its
+// head, oldest first.
+func (t *ImageThread) Turns() []ThreadTurn {
+	path := t.path(t.branches[t.branch].head)
+	out := make([]ThreadTurn, len(path))
+	for i, n := range path {
+		out[i] = *n
+	}
+	return out
+}
+
+// Head returns the latest turn of the current branch.
+func (t *ImageThread) Head() ThreadTurn {
+	return *t.node(t.branches[t.branch].head)
+}
+
+// TurnImage returns the image generated by turn id, or nil if there is no such turn.
+func (t *ImageThread) TurnImage(id int) []byte {
+	if n := t.node(id); n != nil {
+		return n.image
+	}
+	return nil
+}
+
+// Rate records the critique rating of turn id's image.
+func (t *ImageThread) Rate(id int, r *critique.Rating) {
+	if n := t.node(id); n != nil {
+		n.Rating = r
+	}
+}
+
+// ReplaceLastImage swaps the head turn's image for a locally post-processed
+// version (e.g. a masked composite). The provider history keeps the model's own
+// output; the replaced image is what gets saved and sent with the next turn.
+func (t *ImageThread) ReplaceLastImage(img []byte) {
+	turn := t.node(t.branches[t.branch].head)
+	turn.image = img
+	delete(t.saved, turn)
+}
+
+// LastImage returns the image of the current branch's head turn.
+func (t *ImageThread) LastImage() []byte {
+	return t.node(t.branches[t.branch].head).image
+}
@@ -0,0 +1,24 @@
+package ai
+
+import (
+	"net/http"
+)
+
+var (
+	// httpClient carries all provider HTTP traffic (OpenRouter and the genai SDK).
+	httpClient = http.DefaultClient
+	// offline skips API key checks, e.g. while replaying recorded traffic.
+	offline bool
+)
+
+// SetHTTPTransport routes all provider HTTP traffic through rt. When keyless is
+// true, API key checks are skipped so recorded traffic can be replayed without
+// credentials. Passing a nil rt restores the default client.
+func SetHTTPTransport(rt http.RoundTripper, keyless bool) {
+	if rt == nil {
+		httpClient = http.DefaultClient
+	} else {
+		httpClient = &http.Client{Transport: rt}
+	}
+	offline = keyless
+}
@@ -0,0 +1,273 @@
+// Package cassette records HTTP request/response pairs to a directory and replays
+// them later, byte-for-byte, without touching the network.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// redactedHeaders are never written to disk.
+var redactedHeaders = []string{"Authorization", "X-Goog-Api-Key", "Cookie", "Set-Cookie"}
+
+// Interaction is one recorded request/response pair.
+type Interaction struct {
+	Seq      int      `json:"seq"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the recorded side of an outgoing HTTP request.
+type Request struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Header  http.Header `json:"header,omitempty"`
+	BodySHA string      `json:"body_sha256"`
+	Body    string      `json:"body,omitempty"`
+	BodyB64 []byte      `json:"body_b64,omitempty"`
+}
+
+// Response is the recorded HTTP response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Status     string      `json:"status"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyB64    []byte      `json:"body_b64,omitempty"`
+}
+
+// Bytes returns the exact response body.
+func (r Response) Bytes() []byte {
+	if r.BodyB64 != nil {
+		return r.BodyB64
+	}
+	return []byte(r.Body)
+}
+
+// Recorder is an http.RoundTripper that forwards requests to Base and writes each
+// exchange to Dir as NNNN.json.
+type Recorder struct {
+	Dir  string
+	Base http.RoundTripper
+
+	mu  sync.Mutex
+	seq int
+}
+
+// NewRecorder creates dir if needed and returns a Recorder wrapping base
+// (http.DefaultTransport when nil).
+func NewRecorder(dir string, base http.RoundTripper) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{Dir: dir, Base: base, seq: len(existing)}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := drainBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := drainBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.seq++
+	seq := r.seq
+	r.mu.Unlock()
+
+	in := Interaction{
+		Seq: seq,
+		Request: Request{
+			Method:  req.Method,
+			URL:     redactURL(req.URL),
+			Header:  redactHeader(req.Header),
+			BodySHA: bodyHash(reqBody),
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     redactHeader(resp.Header),
+		},
+	}
+	in.Request.Body, in.Request.BodyB64 = encodeBody(reqBody)
+	in.Response.Body, in.Response.BodyB64 = encodeBody(respBody)
+	b, err := json.MarshalIndent(in, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(r.Dir, fmt.Sprintf("%04d.json", seq)), b, 0o644); err != nil {
+		return nil, fmt.Errorf("cassette: write interaction %d: %w", seq, err)
+	}
+	return resp, nil
+}
+
+// Player is an http.RoundTripper that serves recorded interactions instead of
+// making network calls. Requests are matched by method, URL and body hash, so a
+// request whose body differs from the recording fails. Only interactions without
+// a body hash (written by hand) match any body with the same method and URL.
+type Player struct {
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// Load reads every interaction in dir, ordered by sequence number.
+func Load(dir string) (*Player, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("cassette: no interactions found in %s", dir)
+	}
+	p := &Player{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var in Interaction
+		if err := json.Unmarshal(b, &in); err != nil {
+			return nil, fmt.Errorf("cassette: %s: %w", path, err)
+		}
+		p.interactions = append(p.interactions, in)
+	}
+	sort.SliceStable(p.interactions, func(i, j int) bool { return p.interactions[i].Seq < p.interactions[j].Seq })
+	p.used = make([]bool, len(p.interactions))
+	return p, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := drainBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+	u := redactURL(req.URL)
+	sum := bodyHash(body)
+
+	p.mu.Lock()
+	idx := -1
+	for i, in := range p.interactions {
+		if !p.used[i] && in.Request.Method == req.Method && in.Request.URL == u && in.Request.BodySHA == sum {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		for i, in := range p.interactions {
+			if !p.used[i] && in.Request.BodySHA == "" && in.Request.Method == req.Method && in.Request.URL == u {
+				idx = i
+				break
+			}
+		}
+	}
+	if idx >= 0 {
+		p.used[idx] = true
+	}
+	p.mu.Unlock()
+
+	if idx < 0 {
+		return nil, fmt.Errorf("cassette: no recorded interaction for %s %s with body sha256 %s", req.Method, u, sum)
+	}
+	rec := p.interactions[idx].Response
+	respBody := rec.Bytes()
+	return &http.Response{
+		StatusCode:    rec.StatusCode,
+		Status:        rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rec.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// Remaining reports how many recorded interactions have not been replayed yet.
+func (p *Player) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, u := range p.used {
+		if !u {
+			n++
+		}
+	}
+	return n
+}
+
+func drainBody(rc *io.ReadCloser) ([]byte, error) {
+	if *rc == nil || *rc == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(*rc)
+	_ = (*rc).Close()
+	if err != nil {
+		return nil, err
+	}
+	*rc = io.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+func bodyHash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeBody keeps UTF-8 bodies readable and base64-encodes anything else.
+func encodeBody(b []byte) (string, []byte) {
+	if len(b) == 0 {
+		return "", nil
+	}
+	if utf8.Valid(b) {
+		return string(b), nil
+	}
+	return "", b
+}
+
+func redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, k := range redactedHeaders {
+		out.Del(k)
+	}
+	return out
+}
+
+func redactURL(u *url.URL) string {
+	c := *u
+	q := c.Query()
+	if q.Has("key") {
+		q.Del("key")
+		c.RawQuery = q.Encode()
+	}
+	return strings.TrimSuffix(c.String(), "?")
+}
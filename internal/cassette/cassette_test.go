@@ -0,0 +1,94 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("<html>503 " + string(body) + "</html>"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rec}
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/chat/completions", strings.NewReader("ping"))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	player, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if player.interactions[0].Request.Header.Get("Authorization") != "" {
+		t.Fatal("expected Authorization header to be redacted")
+	}
+	client = &http.Client{Transport: player}
+	resp, err = client.Post(srv.URL+"/chat/completions", "text/plain", strings.NewReader("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || string(replayed) != string(live) {
+		t.Fatalf("replay mismatch: status=%d body=%q want %q", resp.StatusCode, replayed, live)
+	}
+	if player.Remaining() != 0 {
+		t.Fatalf("expected all interactions consumed, %d remaining", player.Remaining())
+	}
+	if _, err := client.Post(srv.URL+"/chat/completions", "text/plain", strings.NewReader("ping")); err == nil {
+		t.Fatal("expected an error once the cassette is exhausted")
+	}
+}
+
+func TestReplayMatchesBody(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("0001.json", `{"seq": 1, "request": {"method": "POST", "url": "https://api.test/v1", "body_sha256": "`+bodyHash([]byte("ping"))+`"}, "response": {"status_code": 200, "body": "pong"}}`)
+	player, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: player}
+	if _, err := client.Post("https://api.test/v1", "text/plain", strings.NewReader("other")); err == nil {
+		t.Fatal("a request with a different body must not match a recorded hash")
+	}
+	if resp, err := client.Post("https://api.test/v1", "text/plain", strings.NewReader("ping")); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("expected the recorded response, got %v", err)
+	}
+
+	// Interactions without a hash match any body.
+	write("0002.json", `{"seq": 2, "request": {"method": "POST", "url": "https://api.test/v1", "body_sha256": ""}, "response": {"status_code": 200, "body": "pong"}}`)
+	if player, err = Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: player}
+	if resp, err := client.Post("https://api.test/v1", "text/plain", strings.NewReader("other")); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("expected the unhashed interaction to match, got %v", err)
+	}
+	if player.Remaining() != 1 {
+		t.Fatalf("expected the hashed interaction to stay unused, %d remaining", player.Remaining())
+	}
+}
@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	batchWorkers int
+	batchResults string
+	batchResume  bool
+	batchLoop    loopOptions
+
+	batchCmd = &cobra.Command{
+		Use:   "batch <jobs.jsonl>",
+		Short: "Run many generations from a JSONL job file with a worker pool",
+		Long: `Each line of the job file is a JSON object describing one generation:
+
+  {"id": "panel-1", "prompt": "...", "images": ["dan.png"], "fragments": ["style.txt"],
+   "output": "panels/panel_1.png", "aspect_ratio": "16:9", "resolution": "2K",
+   "model": "gemini-3-pro-image-preview", "critique_loops": 2}
+
+Only prompt and output are required; model defaults to --model. Paths are relative to
+the current directory. One result per job is appended to the results file as it finishes.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBatch(context.Background(), cmd.OutOrStdout(), args[0])
+		},
+		Example: `nano-agent batch jobs.jsonl --workers 4
+nano-agent batch jobs.jsonl --resume   # skip jobs that already succeeded`,
+	}
+)
+
+// batchJob is one line of a batch job file.
+type batchJob struct {
+	ID            string   `json:"id,omitempty"`
+	Images        []string `json:"images,omitempty"`
+	Prompt        string   `json:"prompt"`
+	Fragments     []string `json:"fragments,omitempty"`
+	Output        string   `json:"output"`
+	AspectRatio   string   `json:"aspect_ratio,omitempty"`
+	Resolution    string   `json:"resolution,omitempty"`
+	Model         string   `json:"model,omitempty"`
+	CritiqueLoops int      `json:"critique_loops,omitempty"`
+	// Vars are template variables for this job; --var still overrides them.
+	Vars map[string]string `json:"vars,omitempty"`
+
+	line int
+}
+
+// batchResult is one line of the results file.
+type batchResult struct {
+	ID      string `json:"id"`
+	Line    int    `json:"line"`
+	Status  string `json:"status"` // ok, failed or skipped
+	Output  string `json:"output,omitempty"`
+	Session string `json:"session,omitempty"`
+	Route   string `json:"route,omitempty"` // provider/model that generated the image
+	Error   string `json:"error,omitempty"`
+	// Response holds the model's text, and when no image came back its finish
+	// reason, safety ratings and prompt feedback.
+	Response   *ai.ResponseInfo `json:"response,omitempty"`
+	DurationMS int64            `json:"duration_ms"`
+}
+
+// readBatchJobs parses a JSONL job file, skipping blank lines and # comments.
+func readBatchJobs(path string) ([]batchJob, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var jobs []batchJob
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var job batchJob
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&job); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		job.line = n
+		if job.ID == "" {
+			job.ID = fmt.Sprintf("line-%d", n)
+		}
+		if strings.TrimSpace(job.Prompt) == "" {
+			return nil, fmt.Errorf("%s:%d: job %s has no prompt", path, n, job.ID)
+		}
+		if strings.TrimSpace(job.Output) == "" {
+			return nil, fmt.Errorf("%s:%d: job %s has no output", path, n, job.ID)
+		}
+		if !strings.HasSuffix(strings.ToLower(job.Output), ".png") {
+			job.Output += ".png"
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, scanner.Err()
+}
+
+func runBatch(ctx context.Context, out io.Writer, path string) error {
+	jobs, err := readBatchJobs(path)
+	if err != nil {
+		return err
+	}
+	if batchWorkers < 1 {
+		return fmt.Errorf("--workers must be at least 1")
+	}
+	// Validate the shared loop flags once rather than failing every job.
+	if _, _, err := batchLoop.settings(0); err != nil {
+		return err
+	}
+	if _, err := templateVars(nil); err != nil {
+		return err
+	}
+	resultsPath := batchResults
+	if resultsPath == "" {
+		resultsPath = strings.TrimSuffix(path, filepath.Ext(path)) + ".results.jsonl"
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	var done map[string]string
+	if batchResume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		if done, err = readBatchSucceeded(resultsPath); err != nil {
+			return err
+		}
+	}
+	rf, err := os.OpenFile(resultsPath, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+
+	queue := make(chan batchJob)
+	results := make(chan batchResult)
+	var wg sync.WaitGroup
+	var outMu sync.Mutex
+	for w := 0; w < batchWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				var log bytes.Buffer
+				res := runBatchJob(ctx, &log, job, done)
+				outMu.Lock()
+				fmt.Fprintf(out, "\n=== Job %s (line %d) ===\n", job.ID, job.line)
+				out.Write(log.Bytes())
+				outMu.Unlock()
+				results <- res
+			}
+		}()
+	}
+	go func() {
+		for _, job := range jobs {
+			queue <- job
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	counts := map[string]int{}
+	enc := json.NewEncoder(rf)
+	for res := range results {
+		counts[res.Status]++
+		if err := enc.Encode(res); err != nil {
+			return fmt.Errorf("failed to write results %s: %w", resultsPath, err)
+		}
+		outMu.Lock()
+		fmt.Fprintf(out, "[%d/%d] %s: %s", counts["ok"]+counts["failed"]+counts["skipped"], len(jobs), res.ID, res.Status)
+		if res.Error != "" {
+			fmt.Fprintf(out, " (%s)", res.Error)
+		}
+		fmt.Fprintln(out)
+		outMu.Unlock()
+	}
+	fmt.Fprintf(out, "\nBatch finished: %d ok, %d failed, %d skipped. Results: %s\n", counts["ok"], counts["failed"], counts["skipped"], resultsPath)
+	if counts["failed"] > 0 {
+		return fmt.Errorf("%d of %d jobs failed", counts["failed"], len(jobs))
+	}
+	return nil
+}
+
+// readBatchSucceeded reads a previous results file and maps the ID of every job
+// whose latest run succeeded to its output. A missing file means no job has run.
+func readBatchSucceeded(path string) (map[string]string, error) {
+	done := map[string]string{}
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return done, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var res batchResult
+		if err := json.Unmarshal([]byte(line), &res); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		// Skipped entries record an earlier success and change nothing.
+		switch res.Status {
+		case "ok":
+			done[res.ID] = res.Output
+		case "failed":
+			delete(done, res.ID)
+		}
+	}
+	return done, scanner.Err()
+}
+
+// runBatchJob runs one job, logging to log, and reports its result. Jobs in done
+// (see readBatchSucceeded) with the same output are skipped.
+func runBatchJob(ctx context.Context, log io.Writer, job batchJob, done map[string]string) batchResult {
+	res := batchResult{ID: job.ID, Line: job.line, Output: job.Output}
+	if output, ok := done[job.ID]; ok && output == job.Output {
+		res.Status = "skipped"
+		fmt.Fprintf(log, "Job %s already succeeded; skipping\n", job.ID)
+		return res
+	}
+	start := time.Now()
+	loops, settings, err := batchLoop.settings(job.CritiqueLoops)
+	var vars map[string]string
+	if err == nil {
+		vars, err = templateVars(job.Vars)
+	}
+	if err == nil {
+		g := generation{
+			model:       job.Model,
+			images:      job.Images,
+			prompt:      job.Prompt,
+			fragments:   job.Fragments,
+			vars:        vars,
+			aspectRatio: job.AspectRatio,
+			resolution:  job.Resolution,
+			output:      job.Output,
+			loops:       loops,
+			settings:    settings,
+		}
+		if g.model == "" {
+			g.model = viper.GetString("model")
+		}
+		// --session would make every job share one directory, so batch always
+		// uses each output's default session directory.
+		if !noSession {
+			g.sessionDir = defaultSessionDir(job.Output)
+			res.Session = g.sessionDir
+		}
+		var thread *ai.ImageThread
+		thread, err = g.run(ctx, log)
+		if thread != nil {
+			res.Route = thread.Route()
+		}
+		res.Response = responseInfo(thread, err)
+	}
+	res.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Status = "failed"
+		res.Error = err.Error()
+		fmt.Fprintf(log, "Error: %v\n", err)
+		return res
+	}
+	res.Status = "ok"
+	return res
+}
+
+func init() {
+	batchCmd.Flags().IntVarP(&batchWorkers, "workers", "w", 2, "Number of jobs to run concurrently")
+	batchCmd.Flags().StringVar(&batchResults, "results", "", "Path of the results JSONL (default: <jobs>.results.jsonl)")
+	batchCmd.Flags().BoolVar(&batchResume, "resume", false, "Skip jobs that succeeded in the previous results file and append to it")
+	addLoopFlags(batchCmd.Flags(), &batchLoop)
+	rootCmd.AddCommand(batchCmd)
+}
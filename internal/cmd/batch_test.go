@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rkirkendall/nano-agent/internal/version"
+)
+
+func TestBatchRunsJobsAndResumes(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	jobs := filepath.Join(dir, "jobs.jsonl")
+	lines := strings.Join([]string{
+		`{"id": "a", "prompt": "a red fox", "output": "` + filepath.Join(dir, "a.png") + `", "critique_loops": 1}`,
+		`# comments and blank lines are skipped`,
+		``,
+		`{"prompt": "a blue whale", "output": "` + filepath.Join(dir, "b") + `", "aspect_ratio": "16:9"}`,
+		`{"id": "bad", "prompt": "no such model", "output": "` + filepath.Join(dir, "c.png") + `", "model": "nosuch/model"}`,
+	}, "\n")
+	if err := os.WriteFile(jobs, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (string, []batchResult, error) {
+		var buf bytes.Buffer
+		rootCmd.SetOut(&buf)
+		rootCmd.SetArgs(append([]string{"batch", jobs, "--model", "fake/test", "--workers", "3"}, args...))
+		err := rootCmd.Execute()
+		b, rerr := os.ReadFile(filepath.Join(dir, "jobs.results.jsonl"))
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		var results []batchResult
+		for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			var r batchResult
+			if err := json.Unmarshal([]byte(l), &r); err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, r)
+		}
+		return buf.String(), results, err
+	}
+
+	out, results, err := run()
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 jobs failed") {
+		t.Fatalf("expected the bad job to fail the batch, got %v\n%s", err, out)
+	}
+	status := map[string]string{}
+	for _, r := range results {
+		status[r.ID] = r.Status
+	}
+	if status["a"] != "ok" || status["line-4"] != "ok" || status["bad"] != "failed" {
+		t.Fatalf("unexpected statuses %v\n%s", status, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resume goes by the results file, not by which outputs exist: a deleted
+	// output of a successful job stays skipped, a stray output of a failed job
+	// is no reason to skip it.
+	if err := os.Remove(filepath.Join(dir, "b.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "c.png"), []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer resetFlags(batchCmd, "resume")
+	out, results, _ = run("--resume")
+	status = map[string]string{}
+	for _, r := range results[3:] {
+		status[r.ID] = r.Status
+	}
+	if len(results) != 6 || status["a"] != "skipped" || status["line-4"] != "skipped" || status["bad"] != "failed" {
+		t.Fatalf("expected a and line-4 skipped and bad rerun on resume, got %+v\n%s", results, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.png")); err == nil {
+		t.Fatal("resume regenerated a job that already succeeded")
+	}
+
+	// Skipped entries keep the earlier success.
+	out, results, _ = run("--resume")
+	skipped := 0
+	for _, r := range results[6:] {
+		if r.Status == "skipped" {
+			skipped++
+		}
+	}
+	if len(results) != 9 || skipped != 2 {
+		t.Fatalf("expected the second resume to skip again, got %+v\n%s", results, out)
+	}
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/spf13/cobra"
+)
+
+var (
+	branchCmd = &cobra.Command{
+		Use:   "branch",
+		Short: "List, fork and switch branches of a saved image thread",
+		Long:  "A saved session keeps every turn as a tree. Fork a branch at an earlier turn to try a different instruction without losing the current one; `nano-agent resume` continues whichever branch is checked out.",
+	}
+
+	branchListCmd = &cobra.Command{
+		Use:   "list <session-dir>",
+		Short: "List the branches of a session",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			thread, err := ai.LoadImageThread(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to load session %s: %w", args[0], err)
+			}
+			printBranches(cmd.OutOrStdout(), thread)
+			return nil
+		},
+	}
+
+	branchForkCmd = &cobra.Command{
+		Use:   "fork <session-dir> <turn> <name>",
+		Short: "Create branch <name> starting at turn N and check it out",
+		Args:  cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid turn %q", args[1])
+			}
+			return updateSession(cmd.OutOrStdout(), args[0], func(thread *ai.ImageThread) error {
+				return thread.Fork(args[2], id)
+			})
+		},
+		Example: `nano-agent branch fork outputs/panel_1_session 2 warmer
+nano-agent resume outputs/panel_1_session -p "Use warmer evening light"`,
+	}
+
+	branchCheckoutCmd = &cobra.Command{
+		Use:   "checkout <session-dir> <name>",
+		Short: "Switch the branch that resume continues",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateSession(cmd.OutOrStdout(), args[0], func(thread *ai.ImageThread) error {
+				return thread.Checkout(args[1])
+			})
+		},
+	}
+)
+
+// updateSession loads a session, applies fn and saves it back.
+func updateSession(out io.Writer, dir string, fn func(*ai.ImageThread) error) error {
+	thread, err := ai.LoadImageThread(dir)
+	if err != nil {
+		return fmt.Errorf("failed to load session %s: %w", dir, err)
+	}
+	if err := fn(thread); err != nil {
+		return err
+	}
+	if err := thread.Save(dir); err != nil {
+		return fmt.Errorf("failed to save session %s: %w", dir, err)
+	}
+	fmt.Fprintf(out, "On branch %s at turn %d\n", thread.Branch(), thread.Head().ID)
+	return nil
+}
+
+// printBranches writes one line per branch, marking the current one with '*'.
+func printBranches(out io.Writer, thread *ai.ImageThread) {
+	for _, b := range thread.Branches() {
+		mark := " "
+		if b.Current {
+			mark = "*"
+		}
+		ids := make([]string, len(b.Turns))
+		for i, id := range b.Turns {
+			ids[i] = strconv.Itoa(id)
+		}
+		fmt.Fprintf(out, "%s %-12s head=%d turns=%s\n", mark, b.Name, b.Head, strings.Join(ids, "→"))
+	}
+}
+
+func init() {
+	branchCmd.AddCommand(branchListCmd, branchForkCmd, branchCheckoutCmd)
+	rootCmd.AddCommand(branchCmd)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/rkirkendall/nano-agent/internal/project"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// defaultManifest is the project file build looks for when none is given.
+const defaultManifest = "nano-agent.yaml"
+
+var (
+	buildForce  bool
+	buildPanels []string
+	buildLoop   loopOptions
+
+	buildCmd = &cobra.Command{
+		Use:   "build [manifest.yaml]",
+		Short: "Generate every panel of a project manifest, skipping unchanged panels",
+		Long: `Reads a project manifest that declares characters, places and style fragments once
+and lists panels that reference them by name, then generates each panel. A panel is
+skipped when its output exists and its prompt, settings, reference images and
+fragments are unchanged since the last build (tracked in .nano-agent-build.json next
+to the manifest). Paths in the manifest are relative to its directory.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := defaultManifest
+			if len(args) == 1 {
+				path = args[0]
+			}
+			return runBuild(context.Background(), cmd.OutOrStdout(), path)
+		},
+		Example: `nano-agent build examples/comic/comic.yaml
+nano-agent build examples/comic/comic.yaml --panel panel_2 --force`,
+	}
+)
+
+func runBuild(ctx context.Context, out io.Writer, path string) error {
+	m, err := project.Load(path)
+	if err != nil {
+		return err
+	}
+	if _, _, err := buildLoop.settings(0); err != nil {
+		return err
+	}
+	jobs := m.Jobs()
+	for _, id := range buildPanels {
+		if !slices.ContainsFunc(jobs, func(j project.Job) bool { return j.ID == id }) {
+			return fmt.Errorf("manifest %s has no panel %q", path, id)
+		}
+	}
+	state, err := m.LoadState()
+	if err != nil {
+		return err
+	}
+
+	var built, skipped int
+	for _, job := range jobs {
+		if len(buildPanels) > 0 && !slices.Contains(buildPanels, job.ID) {
+			continue
+		}
+		if job.Vars, err = templateVars(job.Vars); err != nil {
+			return fmt.Errorf("panel %s: %w", job.ID, err)
+		}
+		if job.Model == "" {
+			job.Model = viper.GetString("model")
+		}
+		loops, settings, err := buildLoop.settings(job.CritiqueLoops)
+		if err != nil {
+			return fmt.Errorf("panel %s: %w", job.ID, err)
+		}
+		fingerprint, err := job.Fingerprint(loopInputs(job.Model, loops, settings))
+		if err != nil {
+			return fmt.Errorf("panel %s: %w", job.ID, err)
+		}
+		if !buildForce && state.UpToDate(job, fingerprint) {
+			fmt.Fprintf(out, "Panel %s is up to date: %s\n", job.ID, job.Output)
+			skipped++
+			continue
+		}
+		fmt.Fprintf(out, "\n=== Panel %s ===\n", job.ID)
+		g := generation{
+			model:       job.Model,
+			images:      job.Images,
+			prompt:      job.Prompt,
+			fragments:   job.Fragments,
+			vars:        job.Vars,
+			aspectRatio: job.AspectRatio,
+			resolution:  job.Resolution,
+			output:      job.Output,
+			loops:       loops,
+			settings:    settings,
+		}
+		if !noSession {
+			g.sessionDir = defaultSessionDir(job.Output)
+		}
+		if _, err := g.run(ctx, out); err != nil {
+			return fmt.Errorf("panel %s: %w", job.ID, err)
+		}
+		if err := state.Record(job.ID, fingerprint); err != nil {
+			return err
+		}
+		built++
+	}
+	fmt.Fprintf(out, "\nBuild finished: %d built, %d up to date\n", built, skipped)
+	return nil
+}
+
+// loopInputs lists the critique loop settings that shape a panel's output, for
+// its build fingerprint. Without loops the critic never runs, so its settings
+// are left out.
+func loopInputs(model string, loops int, s loopSettings) map[string]any {
+	if loops == 0 {
+		return nil
+	}
+	in := map[string]any{
+		"loops":          loops,
+		"keep_best":      s.keepBest,
+		"critique_model": critiqueModel(model),
+		"critics":        viper.GetString("critics"),
+		"quorum":         viper.GetInt("quorum"),
+		"mask":           s.mask,
+		"composite":      s.composite,
+		"guard":          s.guard,
+		"on_regression":  s.onRegression,
+	}
+	if s.stop != nil {
+		in["stop_score"], in["min_change"] = s.stop.score, s.stop.minChange
+	}
+	return in
+}
+
+func init() {
+	buildCmd.Flags().BoolVar(&buildForce, "force", false, "Rebuild panels even when their inputs are unchanged")
+	buildCmd.Flags().StringSliceVar(&buildPanels, "panel", nil, "Only build these panel ids")
+	addLoopFlags(buildCmd.Flags(), &buildLoop)
+	rootCmd.AddCommand(buildCmd)
+}
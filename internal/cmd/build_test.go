@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rkirkendall/nano-agent/internal/version"
+)
+
+func TestBuildSkipsUnchangedPanels(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	style := filepath.Join(dir, "style.txt")
+	if err := os.WriteFile(style, []byte("ink and watercolor"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(dir, "comic.yaml")
+	if err := os.WriteFile(manifest, []byte(`
+styles:
+  ink: style.txt
+panels:
+  - id: one
+    prompt: a lighthouse
+  - id: two
+    prompt: a harbor
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	build := func() string {
+		var buf bytes.Buffer
+		rootCmd.SetOut(&buf)
+		rootCmd.SetArgs([]string{"build", manifest, "--model", "fake/test", "--no-session"})
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("build: %v\n%s", err, buf.String())
+		}
+		return buf.String()
+	}
+	defer resetFlags(rootCmd, "no-session")
+
+	if out := build(); !strings.Contains(out, "2 built, 0 up to date") {
+		t.Fatalf("first build:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "panels", "two.png")); err != nil {
+		t.Fatal(err)
+	}
+	if out := build(); !strings.Contains(out, "0 built, 2 up to date") {
+		t.Fatalf("unchanged build:\n%s", out)
+	}
+	// Editing a shared style fragment invalidates every panel using it.
+	if err := os.WriteFile(style, []byte("thick ink outlines"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := build(); !strings.Contains(out, "2 built, 0 up to date") {
+		t.Fatalf("build after style change:\n%s", out)
+	}
+	// So does changing only the settings in its front matter.
+	if err := os.WriteFile(style, []byte("---\naspect_ratio: \"16:9\"\n---\nthick ink outlines"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := build(); !strings.Contains(out, "2 built, 0 up to date") {
+		t.Fatalf("build after front matter change:\n%s", out)
+	}
+	if err := os.WriteFile(style, []byte("---\naspect_ratio: \"4:3\"\n---\nthick ink outlines"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out := build(); !strings.Contains(out, "2 built, 0 up to date") {
+		t.Fatalf("build after aspect ratio change:\n%s", out)
+	}
+
+	// compose lays the built panels out on a page.
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetArgs([]string{"compose", "--manifest", manifest, "--columns", "1"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("compose: %v\n%s", err, buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "panels", "page.png")); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+}
+
+func TestBuildRebuildsWhenResolvedSettingsChange(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "comic.yaml")
+	if err := os.WriteFile(manifest, []byte(`
+panels:
+  - id: one
+    prompt: a lighthouse
+    critique_loops: 1
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	build := func(args ...string) string {
+		var buf bytes.Buffer
+		rootCmd.SetOut(&buf)
+		rootCmd.SetArgs(append([]string{"build", manifest, "--no-session"}, args...))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("build: %v\n%s", err, buf.String())
+		}
+		return buf.String()
+	}
+	defer resetFlags(buildCmd, "keep")
+	defer resetFlags(rootCmd, "no-session", "model", "critique-model")
+
+	steps := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--model", "fake/test"}, "1 built"},
+		{[]string{"--model", "fake/test"}, "1 up to date"},
+		// The model comes from --model, not the manifest.
+		{[]string{"--model", "fake/other"}, "1 built"},
+		{[]string{"--model", "fake/other", "--critique-model", "fake/critic"}, "1 built"},
+		{[]string{"--model", "fake/other", "--critique-model", "fake/critic", "--keep", "best"}, "1 built"},
+		{[]string{"--model", "fake/other", "--critique-model", "fake/critic", "--keep", "best"}, "1 up to date"},
+	}
+	for i, s := range steps {
+		if out := build(s.args...); !strings.Contains(out, s.want) {
+			t.Fatalf("step %d %v: want %q in:\n%s", i, s.args, s.want, out)
+		}
+	}
+}
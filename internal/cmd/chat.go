@@ -0,0 +1,309 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/generate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const chatHelp = `Type an edit instruction to send it as the next turn, or use a command:
+  /undo            drop the last turn and continue from the previous image
+  /fork <turn> <name>  start branch <name> from turn N, keeping the current branch
+  /checkout <name> switch to another branch
+  /branches        list branches and their turns
+  /save [path]     copy the current image to path (default: -o)
+  /critique        run one critique-improve iteration on the current image
+  /attach <image>  attach an extra reference image to the next turn
+  /help            show this help
+  /quit            exit (also Ctrl-D)`
+
+var (
+	chatPrompt      string
+	chatOutput      string
+	chatFragments   []string
+	chatAspectRatio string
+	chatResolution  string
+
+	chatCmd = &cobra.Command{
+		Use:   "chat [images...]",
+		Short: "Edit an image conversationally, one instruction per turn",
+		Long:  "Starts an image thread and reads follow-up edit instructions from stdin. Each turn's image is written to a numbered file next to -o (e.g. chat_001.png, chat_002.png).",
+		Args:  cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runChat(context.Background(), cmd.InOrStdin(), cmd.OutOrStdout(), args)
+		},
+		Example: `nano-agent chat examples/comic/characters/dan.png -p "Dan waving hello" -o chat/dan.png`,
+	}
+)
+
+// chatState tracks the REPL's thread, the numbered file written for each turn and
+// any images queued for the next turn.
+type chatState struct {
+	thread     *ai.ImageThread
+	out        io.Writer
+	base       string
+	sessionDir string
+	fragTexts  []string
+	files      map[int]string
+	pending    []string
+	counter    int
+	loop       *critiqueLoop
+}
+
+func runChat(ctx context.Context, in io.Reader, out io.Writer, images []string) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	readLine := func() (string, bool) {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(scanner.Text()), true
+	}
+
+	initial := strings.TrimSpace(chatPrompt)
+	if initial == "" {
+		fmt.Fprintln(out, "Describe the image to generate:")
+		for initial == "" {
+			line, ok := readLine()
+			if !ok {
+				return nil
+			}
+			initial = line
+		}
+	}
+	output := chatOutput
+	if output == "" {
+		output = "chat.png"
+	}
+	if dir := filepath.Dir(output); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create output dir %s: %w", dir, err)
+		}
+	}
+	vars, err := templateVars(nil)
+	if err != nil {
+		return err
+	}
+	model := viper.GetString("model")
+	aspectRatio, resolution := chatAspectRatio, chatResolution
+	frags, err := resolveFragments(out, model, chatFragments, &aspectRatio, &resolution)
+	if err != nil {
+		return err
+	}
+	fragTexts, err := generate.LoadFragments(frags, vars)
+	if err != nil {
+		return err
+	}
+
+	thread, img, err := ai.StartImageThreadAndGenerate(ctx, model, images, initial, frags, vars, aspectRatio, resolution)
+	if err != nil {
+		return err
+	}
+	s := &chatState{
+		thread:     thread,
+		out:        out,
+		base:       strings.TrimSuffix(output, filepath.Ext(output)),
+		sessionDir: resolveSessionDir(output),
+		fragTexts:  fragTexts,
+		files:      map[int]string{},
+	}
+	if err := s.recordTurn(img); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, chatHelp)
+
+	for {
+		line, ok := readLine()
+		if !ok {
+			fmt.Fprintln(out)
+			return nil
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			s.edit(ctx, line)
+			continue
+		}
+		name, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch name {
+		case "/quit", "/exit":
+			return nil
+		case "/help":
+			fmt.Fprintln(out, chatHelp)
+		case "/undo":
+			s.undo()
+		case "/fork":
+			s.fork(arg)
+		case "/checkout":
+			s.checkout(arg)
+		case "/branches":
+			printBranches(out, s.thread)
+		case "/save":
+			s.save(arg, output)
+		case "/attach":
+			s.attach(arg)
+		case "/critique":
+			s.critique(ctx)
+		default:
+			fmt.Fprintf(out, "Unknown command %s (try /help)\n", name)
+		}
+	}
+}
+
+// current returns the numbered file of the current branch's head turn.
+func (s *chatState) current() string { return s.files[s.thread.Head().ID] }
+
+// recordTurn writes a new turn's image to the next numbered file and saves the session.
+func (s *chatState) recordTurn(img []byte) error {
+	s.counter++
+	p := fmt.Sprintf("%s_%03d.png", s.base, s.counter)
+	if err := os.WriteFile(p, img, 0o644); err != nil {
+		return err
+	}
+	head := s.thread.Head()
+	s.files[head.ID] = p
+	s.thread.SetOutput(p)
+	fmt.Fprintf(s.out, "Turn %d saved at: %s\n", head.ID, p)
+	printModelText(s.out, head)
+	return s.saveSession()
+}
+
+func (s *chatState) saveSession() error {
+	if s.sessionDir == "" {
+		return nil
+	}
+	if err := s.thread.Save(s.sessionDir); err != nil {
+		return fmt.Errorf("failed to save session %s: %w", s.sessionDir, err)
+	}
+	return nil
+}
+
+// report prints a turn error without ending the chat.
+func (s *chatState) report(err error) {
+	if err != nil {
+		fmt.Fprintf(s.out, "Error: %v\n", err)
+	}
+}
+
+func (s *chatState) edit(ctx context.Context, line string) {
+	line, err := generate.Render("instruction", line, s.thread.Vars())
+	if err != nil {
+		s.report(err)
+		return
+	}
+	text := generate.BuildEffectivePrompt(line, s.fragTexts)
+	img, err := s.thread.AddUserMessageWithImages(ctx, text, append([]string{s.current()}, s.pending...))
+	if err != nil {
+		s.report(fmt.Errorf("generation failed: %w", err))
+		return
+	}
+	s.pending = nil
+	s.report(s.recordTurn(img))
+}
+
+func (s *chatState) undo() {
+	if err := s.thread.Undo(); err != nil {
+		s.report(err)
+		return
+	}
+	fmt.Fprintf(s.out, "Reverted to turn %d: %s\n", s.thread.Head().ID, s.current())
+	s.report(s.saveSession())
+}
+
+func (s *chatState) fork(arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) != 2 {
+		fmt.Fprintln(s.out, "Usage: /fork <turn> <name>")
+		return
+	}
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		s.report(fmt.Errorf("invalid turn %q", fields[0]))
+		return
+	}
+	if err := s.thread.Fork(fields[1], id); err != nil {
+		s.report(err)
+		return
+	}
+	fmt.Fprintf(s.out, "On new branch %s at turn %d: %s\n", fields[1], id, s.current())
+	s.report(s.saveSession())
+}
+
+func (s *chatState) checkout(name string) {
+	if err := s.thread.Checkout(name); err != nil {
+		s.report(err)
+		return
+	}
+	fmt.Fprintf(s.out, "On branch %s at turn %d: %s\n", name, s.thread.Head().ID, s.current())
+	s.report(s.saveSession())
+}
+
+func (s *chatState) save(path, fallback string) {
+	if path == "" {
+		path = fallback
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			s.report(err)
+			return
+		}
+	}
+	if err := os.WriteFile(path, s.thread.LastImage(), 0o644); err != nil {
+		s.report(err)
+		return
+	}
+	fmt.Fprintf(s.out, "Current image saved at: %s\n", path)
+}
+
+func (s *chatState) attach(path string) {
+	if path == "" {
+		fmt.Fprintln(s.out, "Usage: /attach <image>")
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		s.report(err)
+		return
+	}
+	s.pending = append(s.pending, path)
+	fmt.Fprintf(s.out, "Attached %s to the next turn (%d pending)\n", path, len(s.pending))
+}
+
+func (s *chatState) critique(ctx context.Context) {
+	// Reuse the loop so edits that persist across /critique calls are escalated.
+	if s.loop == nil {
+		loop, err := newCritiqueLoop(s.thread, s.current(), s.sessionDir)
+		if err != nil {
+			s.report(err)
+			return
+		}
+		s.loop = loop
+	}
+	img, err := s.loop.improve(ctx, s.out, s.thread, s.current())
+	if err != nil {
+		s.report(err)
+		return
+	}
+	s.report(s.recordTurn(img))
+}
+
+func init() {
+	chatCmd.Flags().StringVarP(&chatPrompt, "prompt", "p", "", "Initial prompt (asked interactively when omitted)")
+	chatCmd.Flags().StringVarP(&chatOutput, "output", "o", "chat.png", "Base path for numbered turn images (chat.png -> chat_001.png, ...)")
+	chatCmd.Flags().StringSliceVarP(&chatFragments, "fragment", "f", []string{}, "One or more text files to append as reusable prompt fragments")
+	chatCmd.Flags().StringVar(&chatAspectRatio, "aspect-ratio", "", "Aspect ratio for Gemini 3 generation (e.g., '16:9', '1:1')")
+	chatCmd.Flags().StringVarP(&chatResolution, "resolution", "r", "", "Image resolution for Gemini 3 generation (e.g., '1K', '2K')")
+	rootCmd.AddCommand(chatCmd)
+}
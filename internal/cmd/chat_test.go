@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestChatREPL(t *testing.T) {
+	dir := t.TempDir()
+	viper.Set("model", "fake/test")
+	t.Cleanup(func() { viper.Set("model", nil) })
+	chatPrompt = "a red fox"
+	chatOutput = filepath.Join(dir, "fox.png")
+
+	script := strings.Join([]string{
+		"add falling snow",
+		"/undo",
+		"/attach " + filepath.Join(dir, "fox_001.png"),
+		"make it night",
+		"/critique",
+		"/save " + filepath.Join(dir, "final.png"),
+		"/quit",
+	}, "\n")
+	var out bytes.Buffer
+	if err := runChat(context.Background(), strings.NewReader(script), &out, nil); err != nil {
+		t.Fatalf("chat: %v\n%s", err, out.String())
+	}
+	for _, name := range []string{"fox_001.png", "fox_002.png", "fox_003.png", "fox_004.png", "final.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+	if !strings.Contains(out.String(), "Reverted to turn 1") {
+		t.Errorf("expected undo to revert to turn 1:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "Turn 3 saved at") {
+		t.Errorf("expected three live turns after critique:\n%s", out.String())
+	}
+}
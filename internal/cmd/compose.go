@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rkirkendall/nano-agent/internal/compose"
+	"github.com/rkirkendall/nano-agent/internal/imagediff"
+	"github.com/rkirkendall/nano-agent/internal/project"
+	"github.com/spf13/cobra"
+)
+
+var (
+	composeManifest    string
+	composeOutput      string
+	composeLayout      string
+	composeColumns     int
+	composeRows        string
+	composePage        string
+	composeDPI         int
+	composeGutter      int
+	composeBorder      int
+	composeMargin      int
+	composeBackground  string
+	composeBorderColor string
+	composeFit         string
+
+	composeCmd = &cobra.Command{
+		Use:   "compose [panels...]",
+		Short: "Lay finished panels out on a comic page",
+		Long: `Composes panel images into a single page locally, without calling any model.
+Panels are placed in reading order using a grid (--columns per row) or a rows
+template (--rows 1,2 puts one panel on the first row and two on the second). With
+--manifest the panels are the manifest's outputs, and its optional page: section
+supplies defaults that flags override.
+
+Without --page the page is 2400px wide and each row is as tall as its panels need;
+with --page (a4, letter, 8.5x11in, 210x297mm or 2480x3508) rows share the height and
+panels are fitted (or, with --fit cover, cropped) into their cells.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCompose(cmd, cmd.OutOrStdout(), args)
+		},
+		Example: `nano-agent compose panels/panel_1.png panels/panel_2.png panels/panel_3.png --rows 1,2 -o page.png
+nano-agent compose --manifest examples/comic/comic.yaml --page a4 --dpi 300`,
+	}
+)
+
+func runCompose(cmd *cobra.Command, out io.Writer, paths []string) error {
+	page := &project.Page{}
+	output := composeOutput
+	if composeManifest != "" {
+		if len(paths) > 0 {
+			return fmt.Errorf("use either panel paths or --manifest, not both")
+		}
+		m, err := project.Load(composeManifest)
+		if err != nil {
+			return err
+		}
+		if m.Page != nil {
+			page = m.Page
+		}
+		var pageOutput string
+		paths, pageOutput = m.PageOutputs()
+		if !cmd.Flags().Changed("output") {
+			output = pageOutput
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no panels given: pass panel images or --manifest")
+	}
+	flags := cmd.Flags()
+	pick := func(name, flag, manifest string) string {
+		if flags.Changed(name) || manifest == "" {
+			return flag
+		}
+		return manifest
+	}
+	pickInt := func(name string, flag int, manifest *int) int {
+		if flags.Changed(name) || manifest == nil {
+			return flag
+		}
+		return *manifest
+	}
+
+	l := compose.Layout{
+		Gutter: pickInt("gutter", composeGutter, page.Gutter),
+		Border: pickInt("border", composeBorder, page.Border),
+		Margin: pickInt("margin", composeMargin, page.Margin),
+		DPI:    composeDPI,
+	}
+	if !flags.Changed("dpi") && page.DPI > 0 {
+		l.DPI = page.DPI
+	}
+	if l.Gutter < 0 || l.Border < 0 || l.Margin < 0 {
+		return fmt.Errorf("--gutter, --border and --margin must not be negative")
+	}
+	var err error
+	if l.Background, err = compose.ParseColor(pick("background", composeBackground, page.Background)); err != nil {
+		return err
+	}
+	if l.BorderColor, err = compose.ParseColor(pick("border-color", composeBorderColor, page.BorderColor)); err != nil {
+		return err
+	}
+	switch fit := pick("fit", composeFit, page.Fit); fit {
+	case "contain":
+	case "cover":
+		l.Cover = true
+	default:
+		return fmt.Errorf("invalid --fit %q: use contain or cover", fit)
+	}
+	if size := pick("page", composePage, page.Size); size != "" {
+		if l.Width, l.Height, err = compose.ParsePageSize(size, l.DPI); err != nil {
+			return err
+		}
+	}
+
+	rows := pick("rows", composeRows, page.Rows)
+	layout := pick("layout", composeLayout, page.Layout)
+	if !flags.Changed("layout") && page.Layout == "" && page.Rows != "" {
+		layout = "rows"
+	}
+	if flags.Changed("rows") && !flags.Changed("layout") {
+		layout = "rows"
+	} else if flags.Changed("columns") && !flags.Changed("layout") {
+		layout = "grid"
+	}
+	switch layout {
+	case "grid":
+		cols := composeColumns
+		if !flags.Changed("columns") && page.Columns > 0 {
+			cols = page.Columns
+		}
+		l.Rows = compose.Grid(len(paths), cols)
+	case "rows":
+		if rows == "" {
+			return fmt.Errorf("the rows layout needs --rows, e.g. --rows 1,2")
+		}
+		if l.Rows, err = compose.ParseRows(rows); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid --layout %q: use grid or rows", layout)
+	}
+
+	panels := make([]image.Image, 0, len(paths))
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		img, err := imagediff.Decode(b)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		panels = append(panels, img)
+	}
+	img, err := compose.Page(panels, l)
+	if err != nil {
+		return err
+	}
+	b, err := compose.EncodePNG(img, l.DPI)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(output); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create output dir %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(output, b, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Page with %d panels (%dx%d) saved at: %s\n", len(panels), img.Bounds().Dx(), img.Bounds().Dy(), output)
+	return nil
+}
+
+func init() {
+	f := composeCmd.Flags()
+	f.StringVar(&composeManifest, "manifest", "", "Compose the panels of a project manifest, using its page: section")
+	f.StringVarP(&composeOutput, "output", "o", "page.png", "Output path for the page")
+	f.StringVar(&composeLayout, "layout", "grid", "Layout template: grid or rows")
+	f.IntVar(&composeColumns, "columns", 2, "Panels per row for the grid layout")
+	f.StringVar(&composeRows, "rows", "", "Panels per row for the rows layout, e.g. 1,2")
+	f.StringVar(&composePage, "page", "", "Page size: a4, letter, a4-landscape, WxHin, WxHmm or WxH pixels (default: 2400px wide, height from the panels)")
+	f.IntVar(&composeDPI, "dpi", 300, "Print resolution recorded in the PNG and used for in/mm/named page sizes")
+	f.IntVar(&composeGutter, "gutter", 20, "Space between panels in pixels")
+	f.IntVar(&composeBorder, "border", 4, "Panel border width in pixels (0 for none)")
+	f.IntVar(&composeMargin, "margin", 40, "Page margin in pixels")
+	f.StringVar(&composeBackground, "background", "white", "Page color (#rrggbb, white, black or transparent)")
+	f.StringVar(&composeBorderColor, "border-color", "black", "Panel border color")
+	f.StringVar(&composeFit, "fit", "contain", "How panels fill their cells: contain (letterbox) or cover (crop)")
+	rootCmd.AddCommand(composeCmd)
+}
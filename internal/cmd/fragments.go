@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rkirkendall/nano-agent/internal/generate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fragmentsTag    string
+	fragmentsRender bool
+
+	fragmentsCmd = &cobra.Command{
+		Use:   "fragments",
+		Short: "Browse the fragment library",
+		Long: `Fragments in .nano-agent/fragments (in the current directory) and
+~/.nano-agent/fragments can be passed by name, e.g. -f comic-style. A fragment may
+start with YAML front matter between --- lines declaring description, tags,
+aspect_ratio, resolution and includes (other fragments loaded ahead of it).`,
+	}
+
+	fragmentsListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List library fragments",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			frags, err := generate.ListFragments()
+			if err != nil {
+				return err
+			}
+			printFragments(cmd.OutOrStdout(), frags, fragmentsTag)
+			return nil
+		},
+		Example: `nano-agent fragments list
+nano-agent fragments list --tag comic`,
+	}
+
+	fragmentsShowCmd = &cobra.Command{
+		Use:   "show <name|path>",
+		Short: "Show a fragment's metadata and text",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return showFragment(cmd.OutOrStdout(), args[0])
+		},
+		Example: `nano-agent fragments show comic-style
+nano-agent fragments show comic-style --render --var character=Dan`,
+	}
+)
+
+// printFragments writes one line per fragment, optionally only those tagged tag.
+func printFragments(out io.Writer, frags []*generate.Fragment, tag string) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTAGS\tDESCRIPTION\tPATH")
+	for _, f := range frags {
+		if tag != "" && !slices.Contains(f.Tags, tag) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Name, strings.Join(f.Tags, ","), f.Description, f.Path)
+	}
+	w.Flush()
+}
+
+func showFragment(out io.Writer, ref string) error {
+	path, err := generate.FindFragment(ref)
+	if err != nil {
+		return err
+	}
+	f, err := generate.ReadFragment(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Name: %s\nPath: %s\n", f.Name, f.Path)
+	for _, field := range []struct{ label, value string }{
+		{"Description", f.Description},
+		{"Tags", strings.Join(f.Tags, ", ")},
+		{"Aspect ratio", f.AspectRatio},
+		{"Resolution", f.Resolution},
+		{"Includes", strings.Join(f.Includes, ", ")},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(out, "%s: %s\n", field.label, field.value)
+		}
+	}
+	fmt.Fprintln(out)
+	if !fragmentsRender {
+		fmt.Fprintln(out, strings.TrimSpace(f.Body))
+		return nil
+	}
+	// Show exactly what a generation would append: includes first, rendered.
+	vars, err := templateVars(nil)
+	if err != nil {
+		return err
+	}
+	texts, err := generate.LoadFragments([]string{path}, vars)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, strings.Join(texts, "\n\n"))
+	return nil
+}
+
+func init() {
+	fragmentsListCmd.Flags().StringVar(&fragmentsTag, "tag", "", "Only list fragments with this tag")
+	fragmentsShowCmd.Flags().BoolVar(&fragmentsRender, "render", false, "Show the text with includes expanded and variables (--var, --vars-file) substituted")
+	fragmentsCmd.AddCommand(fragmentsListCmd, fragmentsShowCmd)
+	rootCmd.AddCommand(fragmentsCmd)
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rkirkendall/nano-agent/internal/version"
+)
+
+func TestNamedFragments(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Chdir(dir)
+	lib := filepath.Join(dir, ".nano-agent", "fragments")
+	if err := os.MkdirAll(lib, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	style := "---\ndescription: Pop-art comic style\ntags: [comic]\naspect_ratio: \"16:9\"\n---\nBold lines and ben-day dots.\n"
+	if err := os.WriteFile(filepath.Join(lib, "comic-style.txt"), []byte(style), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer resetFlags(rootCmd, "fragment", "aspect-ratio", "no-session")
+
+	run := func(args ...string) string {
+		var buf bytes.Buffer
+		rootCmd.SetOut(&buf)
+		rootCmd.SetArgs(args)
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, buf.String())
+		}
+		return buf.String()
+	}
+	if out := run("fragments", "list", "--tag", "comic"); !strings.Contains(out, "comic-style") || !strings.Contains(out, "Pop-art comic style") {
+		t.Fatalf("fragments list:\n%s", out)
+	}
+	if out := run("fragments", "show", "comic-style"); !strings.Contains(out, "Aspect ratio: 16:9") || !strings.Contains(out, "Bold lines") {
+		t.Fatalf("fragments show:\n%s", out)
+	}
+	out := run("--model", "fake/test", "-p", "a lighthouse", "-f", "comic-style", "-o", filepath.Join(dir, "out.png"), "--no-session")
+	if !strings.Contains(out, "Using aspect ratio 16:9 recommended by fragment comic-style") {
+		t.Fatalf("expected the recommended aspect ratio to apply:\n%s", out)
+	}
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/generate"
+)
+
+// generation is one prompt-to-image run: the initial generation followed by
+// optional critique-improve loops. The root command runs one; batch runs many.
+type generation struct {
+	model       string
+	images      []string
+	prompt      string
+	fragments   []string
+	vars        map[string]string
+	aspectRatio string
+	resolution  string
+	// output is the PNG path; it must already end in .png.
+	output string
+	// sessionDir is where the thread is saved, or "" to not save it.
+	sessionDir string
+	loops      int
+	settings   loopSettings
+}
+
+// run generates and improves the image. It returns the thread once the first
+// image exists, even when a later step fails, so callers can report its route.
+func (g generation) run(ctx context.Context, out io.Writer) (*ai.ImageThread, error) {
+	fragments, err := resolveFragments(out, g.model, g.fragments, &g.aspectRatio, &g.resolution)
+	if err != nil {
+		return nil, err
+	}
+	g.fragments = fragments
+	if dir := filepath.Dir(g.output); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create output dir %s: %w", dir, err)
+		}
+	}
+
+	thread, imgBytes, err := ai.StartImageThreadAndGenerate(ctx, g.model, g.images, g.prompt, g.fragments, g.vars, g.aspectRatio, g.resolution)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(g.output, imgBytes, 0o644); err != nil {
+		return thread, err
+	}
+	fmt.Fprintf(out, "Generated image saved at: %s\n", g.output)
+	printModelText(out, thread.Head())
+	if thread.Model() != g.model {
+		fmt.Fprintf(out, "Served by fallback route %s (%s was unavailable)\n", thread.Route(), g.model)
+	}
+	thread.SetOutput(g.output)
+
+	loop, err := newCritiqueLoop(thread, g.output, g.sessionDir)
+	if err != nil {
+		return thread, err
+	}
+	loop.loopSettings = g.settings
+	if err := loop.saveSession(thread); err != nil {
+		return thread, err
+	}
+	if loop.sessionDir != "" {
+		fmt.Fprintf(out, "Session saved at: %s\n", loop.sessionDir)
+	}
+
+	if g.loops > 0 {
+		if err := loop.run(ctx, out, thread, g.loops); err != nil {
+			return thread, err
+		}
+	}
+	return thread, nil
+}
+
+// responseInfo returns what the model reported for a run's JSON results: the
+// failed response's details when err carries them, otherwise the text returned
+// with the latest image, or nil when there is nothing to report.
+func responseInfo(thread *ai.ImageThread, err error) *ai.ResponseInfo {
+	if r := ai.ResponseOf(err); r != nil {
+		return r
+	}
+	if thread != nil {
+		if text := strings.TrimSpace(thread.Head().Text); text != "" {
+			return &ai.ResponseInfo{Text: text}
+		}
+	}
+	return nil
+}
+
+// printModelText shows any text the model returned with a turn's image.
+func printModelText(out io.Writer, turn ai.ThreadTurn) {
+	if text := strings.TrimSpace(turn.Text); text != "" {
+		fmt.Fprintf(out, "Model text: %s\n", text)
+	}
+}
+
+// resolveFragments turns fragment references (paths or library names) into paths.
+// When the run does not set an aspect ratio or resolution and the model supports
+// them, the first ones recommended by a fragment's front matter are applied.
+func resolveFragments(out io.Writer, model string, refs []string, aspectRatio, resolution *string) ([]string, error) {
+	// Validate that fragments are text files, not images
+	for _, f := range refs {
+		ext := strings.ToLower(filepath.Ext(f))
+		switch ext {
+		case ".png", ".jpg", ".jpeg", ".webp", ".gif":
+			return nil, fmt.Errorf("--fragment expects text files; got image file: %s", f)
+		}
+	}
+	paths, err := generate.ResolveFragments(refs)
+	if err != nil {
+		return nil, err
+	}
+	if *aspectRatio != "" && *resolution != "" {
+		return paths, nil
+	}
+	frags, err := generate.ExpandFragments(paths)
+	if err != nil {
+		return nil, err
+	}
+	ar, arFrom, res, resFrom := generate.Recommended(frags)
+	if ar == "" && res == "" {
+		return paths, nil
+	}
+	if caps, err := ai.ModelCapabilities(model); err != nil || !caps.ImageConfig {
+		return paths, nil
+	}
+	if *aspectRatio == "" && ar != "" {
+		*aspectRatio = ar
+		fmt.Fprintf(out, "Using aspect ratio %s recommended by fragment %s\n", ar, arFrom)
+	}
+	if *resolution == "" && res != "" {
+		*resolution = res
+		fmt.Fprintf(out, "Using resolution %s recommended by fragment %s\n", res, resFrom)
+	}
+	return paths, nil
+}
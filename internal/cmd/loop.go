@@ -0,0 +1,587 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/critique"
+	"github.com/rkirkendall/nano-agent/internal/generate"
+	"github.com/rkirkendall/nano-agent/internal/imagediff"
+	"github.com/rkirkendall/nano-agent/internal/mask"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// critiqueLoop holds the settings shared by every critique-improve iteration of a thread.
+type critiqueLoop struct {
+	model string
+	// critics lists the models of a critic ensemble; empty means model alone.
+	critics []string
+	// quorum is how many critics must flag an issue for it to be kept.
+	quorum     int
+	prompt     string
+	fragments  []string
+	vars       map[string]string
+	images     []string
+	output     string
+	sessionDir string
+	verbose    bool
+	// previous is the critique of the last accepted iteration, used to escalate
+	// edits that persist.
+	previous *critique.Result
+	loopSettings
+}
+
+// loopSettings are the per-command loop flags once validated.
+type loopSettings struct {
+	// stop ends run early once the image has converged; nil runs every iteration.
+	stop *stopPolicy
+	// keepBest writes the highest-rated iteration to the output instead of the last.
+	keepBest bool
+	// mask sends a mask of the critique's regions along with the image.
+	mask bool
+	// composite keeps only the masked region of each new image, blended onto the
+	// previous one, so unaffected areas never drift.
+	composite bool
+	// guard is the fraction of pixels outside the critique's regions that may
+	// change before an iteration counts as a regression (0 disables).
+	guard float64
+	// onRegression is what to do with a regression: warn, reject or retry.
+	onRegression string
+}
+
+// guardRetries is how many times a regressed iteration is regenerated with
+// --on-regression retry before it is rejected.
+const guardRetries = 2
+
+// errRejected reports an iteration that was undone by the regression guard.
+var errRejected = errors.New("iteration rejected by the regression guard")
+
+// loopOptions holds the raw loop flags shared by generation and resume.
+type loopOptions struct {
+	maxLoops  int
+	stop      stopPolicy
+	keep      string
+	mask      bool
+	composite bool
+	guard     float64
+	onRegress string
+	// flags is the flag set the options were registered on, so settings can tell
+	// an explicit --guard from its default.
+	flags *pflag.FlagSet
+}
+
+// stopPolicy decides when a critique loop has converged.
+type stopPolicy struct {
+	// score stops the loop once the critic rates the image at least this high (0 disables).
+	score float64
+	// minChange stops the loop when an iteration changes the image by less than
+	// this mean per-pixel fraction (0 disables).
+	minChange float64
+}
+
+// converged reports why the critiqued image needs no further iteration, or "".
+func (p *stopPolicy) converged(res *critique.Result) string {
+	if res == nil {
+		return ""
+	}
+	if r := res.Rating(); p.score > 0 && r != nil && r.Score >= p.score {
+		return fmt.Sprintf("critique score %.1f reached --stop-score %g", r.Score, p.score)
+	}
+	if res.Blocking() == 0 {
+		return "critique reports no CRITICAL or MAJOR edits"
+	}
+	return ""
+}
+
+// stalled reports why the image stopped changing between iterations, or "".
+func (p *stopPolicy) stalled(before, after []byte) string {
+	if p.minChange <= 0 || len(before) == 0 {
+		return ""
+	}
+	delta, err := imagediff.MeanDelta(before, after)
+	if err != nil || delta >= p.minChange {
+		return ""
+	}
+	return fmt.Sprintf("image changed by %.2f%%, below --min-change %.2f%%", delta*100, p.minChange*100)
+}
+
+// addLoopFlags registers the convergence, selection and masking flags shared by
+// generation and resume.
+func addLoopFlags(fs *pflag.FlagSet, o *loopOptions) {
+	o.flags = fs
+	fs.StringVar(&o.keep, "keep", "last", "Which iteration to write to the output: 'last' or 'best' (highest critique score)")
+	fs.IntVar(&o.maxLoops, "max-loops", 0, "Run critique-improve loops until the image converges, at most this many")
+	fs.Float64Var(&o.stop.score, "stop-score", 0, "With --max-loops, stop once the critique scores the image at least this high (0-10; 0 disables)")
+	fs.Float64Var(&o.stop.minChange, "min-change", 0.005, "With --max-loops, stop when an iteration changes less than this fraction of the image (0 disables)")
+	fs.BoolVar(&o.mask, "mask", false, "Send a mask of the critique's bounding boxes and points with each improvement so edits stay in those regions")
+	fs.BoolVar(&o.composite, "composite", false, "Keep only the critique's regions of each new image, blended onto the previous image")
+	fs.Float64Var(&o.guard, "guard", 0.15, "Flag an iteration when more than this fraction of the image outside the critique's regions changes (0 disables; on by default only with --mask or --composite)")
+	fs.StringVar(&o.onRegress, "on-regression", "warn", "What to do with a flagged iteration: 'warn', 'reject' (undo it) or 'retry' (regenerate, then reject)")
+}
+
+// settings validates the options. fixed is --critique-loops, which always runs
+// N iterations; --max-loops instead runs until converged, at most N.
+func (o *loopOptions) settings(fixed int) (int, loopSettings, error) {
+	var s loopSettings
+	switch strings.ToLower(strings.TrimSpace(o.keep)) {
+	case "", "last":
+	case "best":
+		s.keepBest = true
+	default:
+		return 0, s, fmt.Errorf("invalid --keep %q: use 'last' or 'best'", o.keep)
+	}
+	s.mask, s.composite = o.mask, o.composite
+	// The guard diffs every iteration against the critique's regions, so plain
+	// critique loops only pay for it when --guard is given.
+	if o.mask || o.composite || (o.flags != nil && o.flags.Changed("guard")) {
+		s.guard = o.guard
+	}
+	switch s.onRegression = strings.ToLower(strings.TrimSpace(o.onRegress)); s.onRegression {
+	case "", "warn":
+		s.onRegression = "warn"
+	case "reject", "retry":
+	default:
+		return 0, s, fmt.Errorf("invalid --on-regression %q: use 'warn', 'reject' or 'retry'", o.onRegress)
+	}
+	switch {
+	case fixed > 0 && o.maxLoops > 0:
+		return 0, s, fmt.Errorf("use either --critique-loops or --max-loops, not both")
+	case o.maxLoops > 0:
+		stop := o.stop
+		s.stop = &stop
+		return o.maxLoops, s, nil
+	default:
+		return fixed, s, nil
+	}
+}
+
+// newCritiqueLoop derives loop settings from a (possibly resumed) thread.
+func newCritiqueLoop(thread *ai.ImageThread, output, sessionDir string) (*critiqueLoop, error) {
+	model := critiqueModel(thread.Model())
+	critics, err := criticModels(viper.GetString("critics"), model)
+	if err != nil {
+		return nil, err
+	}
+	if len(critics) == 1 {
+		model, critics = critics[0], nil
+	}
+	quorum := viper.GetInt("quorum")
+	if quorum <= 0 {
+		quorum = len(critics)/2 + 1
+	}
+	if len(critics) > 0 && quorum > len(critics) {
+		return nil, fmt.Errorf("--quorum %d exceeds the %d critics", quorum, len(critics))
+	}
+	return &critiqueLoop{
+		model:      model,
+		critics:    critics,
+		quorum:     quorum,
+		prompt:     thread.Prompt(),
+		fragments:  thread.Fragments(),
+		vars:       thread.Vars(),
+		images:     thread.InputImagePaths(),
+		output:     output,
+		sessionDir: sessionDir,
+		verbose:    verbose,
+	}, nil
+}
+
+// criticModels parses --critics: a count of critics all using model, or a
+// comma-separated list of critic models. Empty means a single critic.
+func criticModels(spec, model string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 1 {
+			return nil, fmt.Errorf("--critics must be at least 1, got %d", n)
+		}
+		models := make([]string, n)
+		for i := range models {
+			models[i] = model
+		}
+		return models, nil
+	}
+	var models []string
+	for _, m := range strings.Split(spec, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models, nil
+}
+
+// critiqueModel returns the --critique-model / critique_model setting, falling
+// back to the generation model. It is resolved to a provider on its own, so
+// critique can run natively while generation goes through another provider.
+func critiqueModel(generationModel string) string {
+	if m := strings.TrimSpace(viper.GetString("critique_model")); m != "" {
+		return m
+	}
+	return generationModel
+}
+
+// saveSession persists the thread when a session directory is configured.
+func (l *critiqueLoop) saveSession(thread *ai.ImageThread) error {
+	if l.sessionDir == "" {
+		return nil
+	}
+	if err := thread.Save(l.sessionDir); err != nil {
+		return fmt.Errorf("failed to save session %s: %w", l.sessionDir, err)
+	}
+	return nil
+}
+
+// run performs loops critique-improve iterations on thread, writing each improved
+// image to the output path and a numbered copy under outputs/. Iteration numbers
+// continue from the thread's existing turns so resumed sessions never overwrite
+// earlier copies.
+func (l *critiqueLoop) run(ctx context.Context, out io.Writer, thread *ai.ImageThread, loops int) error {
+	baseOutputPath := l.output
+	baseDir := filepath.Dir(baseOutputPath)
+	baseName := strings.TrimSuffix(filepath.Base(baseOutputPath), filepath.Ext(baseOutputPath))
+	outputsDir := filepath.Join(baseDir, "outputs")
+	_ = os.MkdirAll(outputsDir, 0o755)
+
+	offset := len(thread.Turns()) - 1
+	currentImagePath := baseOutputPath
+	for i := 1; i <= loops; i++ {
+		fmt.Fprintf(out, "\n=== Critique loop %d/%d ===\n", i, loops)
+		result, critiqueText, err := l.critique(ctx, out, thread, currentImagePath)
+		if err != nil {
+			return err
+		}
+		if l.stop != nil {
+			if reason := l.stop.converged(result); reason != "" {
+				fmt.Fprintf(out, "Converged: %s; stopping after %d of %d loops\n", reason, i-1, loops)
+				break
+			}
+		}
+		previous := thread.LastImage()
+		imgBytes, err := l.apply(ctx, out, thread, currentImagePath, result, critiqueText)
+		if errors.Is(err, errRejected) {
+			fmt.Fprintln(out, "Rejected the iteration; keeping the previous image")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(baseOutputPath, imgBytes, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Improved image saved at: %s\n", baseOutputPath)
+		printModelText(out, thread.Head())
+		if l.verbose {
+			sum2 := sha256.Sum256(imgBytes)
+			fmt.Fprintf(out, "Updated image: size=%d bytes sha256=%x\n", len(imgBytes), sum2)
+		}
+		copyPath := filepath.Join(outputsDir, fmt.Sprintf("%s_improved_%d.png", baseName, offset+i))
+		if err := os.WriteFile(copyPath, imgBytes, 0o644); err == nil {
+			fmt.Fprintf(out, "Iteration copy saved at: %s\n", copyPath)
+		}
+		if err := l.saveSession(thread); err != nil {
+			return err
+		}
+		if l.stop != nil {
+			if reason := l.stop.stalled(previous, imgBytes); reason != "" {
+				fmt.Fprintf(out, "Converged: %s; stopping after %d of %d loops\n", reason, i, loops)
+				break
+			}
+		}
+	}
+	if l.keepBest {
+		return l.keepBestTurn(ctx, out, thread)
+	}
+	return nil
+}
+
+// keepBestTurn writes the highest-rated turn on the current branch to the output
+// path, scoring the latest image first if it has not been critiqued. Ties go to the
+// later turn. The thread itself still continues from its latest turn.
+func (l *critiqueLoop) keepBestTurn(ctx context.Context, out io.Writer, thread *ai.ImageThread) error {
+	if thread.Head().Rating == nil {
+		fmt.Fprintln(out, "\n=== Scoring final image ===")
+		if _, _, err := l.critique(ctx, out, thread, l.output); err != nil {
+			return err
+		}
+		if err := l.saveSession(thread); err != nil {
+			return err
+		}
+	}
+	var best *ai.ThreadTurn
+	turns := thread.Turns()
+	for i := range turns {
+		if r := turns[i].Rating; r != nil && (best == nil || r.Score >= best.Rating.Score) {
+			best = &turns[i]
+		}
+	}
+	if best == nil {
+		fmt.Fprintln(out, "No iteration could be scored; keeping the latest image")
+		return nil
+	}
+	if err := os.WriteFile(l.output, thread.TurnImage(best.ID), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Kept best iteration: turn %d scored %s; saved at: %s\n", best.ID, best.Rating, l.output)
+	return nil
+}
+
+// improve critiques the image at currentImagePath and sends the resulting
+// improvement prompt to the thread, returning the newly generated image.
+func (l *critiqueLoop) improve(ctx context.Context, out io.Writer, thread *ai.ImageThread, currentImagePath string) ([]byte, error) {
+	result, critiqueText, err := l.critique(ctx, out, thread, currentImagePath)
+	if err != nil {
+		return nil, err
+	}
+	return l.apply(ctx, out, thread, currentImagePath, result, critiqueText)
+}
+
+// critique asks the critic about the image at currentImagePath, which must be the
+// thread's latest image, and records its rating on that turn. The result is nil
+// when the critic never produced valid JSON; its text is still returned.
+func (l *critiqueLoop) critique(ctx context.Context, out io.Writer, thread *ai.ImageThread, currentImagePath string) (*critique.Result, string, error) {
+	if l.verbose {
+		if b, err := os.ReadFile(currentImagePath); err == nil {
+			sum := sha256.Sum256(b)
+			fmt.Fprintf(out, "Critiquing image with %s: size=%d bytes sha256=%x\n", l.model, len(b), sum)
+		}
+	}
+	var result *critique.Result
+	var critiqueText string
+	var err error
+	if len(l.critics) > 1 {
+		result, critiqueText, err = l.ensemble(ctx, out, currentImagePath)
+		if err == nil && result == nil {
+			err = fmt.Errorf("%w: no critic produced valid JSON", critique.ErrInvalid)
+		}
+	} else {
+		result, critiqueText, err = ai.GenerateStructuredCritique(ctx, l.model, currentImagePath, l.prompt, l.fragments, l.vars, l.images)
+	}
+	if err != nil && !errors.Is(err, critique.ErrInvalid) {
+		return nil, "", fmt.Errorf("critique failed: %w", err)
+	}
+	fmt.Fprintln(out, "Critique feedback:")
+	fmt.Fprintln(out, critiqueText)
+	if result == nil {
+		fmt.Fprintf(out, "Warning: %v; using the critique as plain text\n", err)
+		return nil, critiqueText, nil
+	}
+	if r := result.Rating(); r != nil {
+		thread.Rate(thread.Head().ID, r)
+		fmt.Fprintf(out, "Score: %s\n", r)
+	}
+	return result, critiqueText, nil
+}
+
+// ensemble runs every critic concurrently and merges their edits, keeping only
+// issues flagged by a quorum. Critics that fail are reported and left out; the
+// quorum shrinks to the number of critics that answered. When no critic produced
+// valid JSON the result is nil and the text is every critique concatenated.
+func (l *critiqueLoop) ensemble(ctx context.Context, out io.Writer, currentImagePath string) (*critique.Result, string, error) {
+	type reply struct {
+		result *critique.Result
+		text   string
+		err    error
+	}
+	replies := make([]reply, len(l.critics))
+	var wg sync.WaitGroup
+	for i, model := range l.critics {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, text, err := ai.GenerateStructuredCritique(ctx, model, currentImagePath, l.prompt, l.fragments, l.vars, l.images)
+			replies[i] = reply{r, text, err}
+		}()
+	}
+	wg.Wait()
+
+	var results []*critique.Result
+	var texts []string
+	var firstErr error
+	for i, r := range replies {
+		name := fmt.Sprintf("Critic %d/%d (%s)", i+1, len(l.critics), l.critics[i])
+		switch {
+		case r.result != nil:
+			results = append(results, r.result)
+			if l.verbose {
+				fmt.Fprintf(out, "%s:\n%s\n", name, r.text)
+			}
+		case errors.Is(r.err, critique.ErrInvalid):
+			texts = append(texts, r.text)
+			fmt.Fprintf(out, "%s returned invalid JSON: %v\n", name, r.err)
+		default:
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			fmt.Fprintf(out, "%s failed: %v\n", name, r.err)
+		}
+	}
+	if len(results) == 0 {
+		if len(texts) == 0 {
+			return nil, "", firstErr
+		}
+		return nil, strings.Join(texts, "\n\n"), nil
+	}
+	quorum := min(l.quorum, len(results))
+	merged := critique.Merge(results, quorum)
+	var total int
+	for _, r := range results {
+		total += len(r.Edits)
+	}
+	fmt.Fprintf(out, "Merged %d critiques (quorum %d): kept %d edits from %d proposed\n", len(results), quorum, len(merged.Edits), total)
+	return merged, merged.JSON(), nil
+}
+
+// apply turns a critique into an improvement prompt and generates the next image.
+func (l *critiqueLoop) apply(ctx context.Context, out io.Writer, thread *ai.ImageThread, currentImagePath string, result *critique.Result, critiqueText string) ([]byte, error) {
+	var improvementPrompt string
+	if result != nil {
+		if l.previous != nil {
+			fmt.Fprintln(out, summarizeFollowup(critique.Compare(l.previous, result)))
+		}
+		if escalation := critique.EscalateUnresolved(l.previous, result); escalation != "" {
+			critiqueText = escalation + "\n\n" + critiqueText
+		}
+		improvementPrompt = generate.BuildImprovementPromptWithActions(l.prompt, critiqueText, result.JSON())
+	} else {
+		// The critic never produced valid JSON; pass its text through as prose.
+		improvementPrompt = generate.BuildImprovementPrompt(l.prompt, critiqueText)
+	}
+	// Re-attach fragments explicitly by composing them into the prompt each loop
+	fragTexts, err := generate.LoadFragments(l.fragments, l.vars)
+	if err != nil {
+		return nil, err
+	}
+	effectivePrompt := generate.BuildEffectivePrompt(improvementPrompt, fragTexts)
+	if l.verbose {
+		fmt.Fprintf(out, "Attaching %d original input images and %d fragments this iteration\n", len(l.images), len(l.fragments))
+	}
+
+	current, err := os.ReadFile(currentImagePath)
+	if err != nil {
+		return nil, err
+	}
+	var m *image.Gray
+	diff := (l.mask || l.composite || l.guard > 0) && result != nil
+	if diff {
+		m, diff = l.buildMask(out, current, result)
+	}
+	inputs := []string{currentImagePath}
+	var maskPath string
+	if m != nil && l.mask {
+		maskPath = filepath.Join(filepath.Dir(l.output), "outputs", fmt.Sprintf("%s_mask_%d.png", strings.TrimSuffix(filepath.Base(l.output), filepath.Ext(l.output)), thread.Head().ID))
+		b, err := mask.EncodePNG(m)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(maskPath), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(maskPath, b, 0o644); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(out, "Edit mask saved at: %s\n", maskPath)
+		effectivePrompt += "\n\n" + generate.BuildMaskInstruction(ai.MaskLabel)
+	}
+
+	for attempt := 0; ; attempt++ {
+		imgBytes, err := thread.AddUserMessageWithMask(ctx, effectivePrompt, inputs, maskPath)
+		if err != nil {
+			return nil, fmt.Errorf("improvement generation failed: %w", err)
+		}
+		if m != nil && l.composite {
+			composited, err := mask.Composite(current, imgBytes, m)
+			if err != nil {
+				fmt.Fprintf(out, "Warning: %v; keeping the full generated image\n", err)
+			} else {
+				thread.ReplaceLastImage(composited)
+				fmt.Fprintln(out, "Composited the edited regions onto the previous image")
+				imgBytes = composited
+			}
+		}
+		if !diff || !l.checkRegression(out, current, imgBytes, m, result) || l.onRegression == "warn" {
+			// Only an accepted iteration's critique is followed up on; a rejected
+			// one's edits were never applied.
+			if result != nil {
+				l.previous = result
+			}
+			return imgBytes, nil
+		}
+		if err := thread.Undo(); err != nil {
+			return nil, err
+		}
+		if l.onRegression == "reject" || attempt == guardRetries {
+			return nil, errRejected
+		}
+		fmt.Fprintf(out, "Retrying the iteration (%d/%d)\n", attempt+1, guardRetries)
+	}
+}
+
+// checkRegression reports how the new image differs from the current one and
+// whether the area outside the critique's regions, which keep_notes protect,
+// changed by more than the guard allows. Images that cannot be decoded locally
+// skip the guard with a warning.
+func (l *critiqueLoop) checkRegression(out io.Writer, current, next []byte, m *image.Gray, result *critique.Result) bool {
+	report, err := imagediff.Compare(current, next, m)
+	if err != nil {
+		fmt.Fprintf(out, "Warning: %v; skipping the regression guard for this iteration\n", err)
+		return false
+	}
+	fmt.Fprintf(out, "Change: %s\n", report)
+	if l.guard <= 0 || m == nil || report.SizeChanged || report.Protected <= l.guard {
+		return false
+	}
+	fmt.Fprintf(out, "Warning: %.1f%% of the area outside the edit regions changed (guard %.1f%%)", report.Protected*100, l.guard*100)
+	if len(result.KeepNotes) > 0 {
+		fmt.Fprintf(out, "; keep: %s", strings.Join(result.KeepNotes, "; "))
+	}
+	fmt.Fprintln(out)
+	return true
+}
+
+// buildMask rasterizes the critique's regions at the size of the current image.
+// It returns nil when no edit names a region, so the whole image may change. ok
+// is false when the current image cannot be decoded locally (e.g. WebP); the
+// iteration then runs without a mask, compositing or the regression guard.
+func (l *critiqueLoop) buildMask(out io.Writer, current []byte, result *critique.Result) (m *image.Gray, ok bool) {
+	img, err := imagediff.Decode(current)
+	if err != nil {
+		fmt.Fprintf(out, "Warning: %v; skipping the mask and regression guard for this iteration\n", err)
+		return nil, false
+	}
+	m, found := mask.Build(img.Bounds().Dx(), img.Bounds().Dy(), result.Edits)
+	if !found {
+		fmt.Fprintln(out, "No edit names a region; editing the whole image")
+		return nil, true
+	}
+	fmt.Fprintf(out, "Edit regions cover %.1f%% of the image\n", mask.Coverage(m)*100)
+	return m, true
+}
+
+// summarizeFollowup reports how many edits were resolved, persisted or are new.
+func summarizeFollowup(tracked []critique.Tracked) string {
+	counts := map[critique.Status]int{}
+	for _, t := range tracked {
+		counts[t.Status]++
+	}
+	return fmt.Sprintf("Edits since last critique: %d resolved, %d persisting, %d new",
+		counts[critique.StatusResolved], counts[critique.StatusPersisting], counts[critique.StatusNew])
+}
+
+// defaultSessionDir is where a run's thread is saved when --session is not given.
+func defaultSessionDir(output string) string {
+	baseName := strings.TrimSuffix(filepath.Base(output), filepath.Ext(output))
+	return filepath.Join(filepath.Dir(output), "outputs", baseName+"_session")
+}
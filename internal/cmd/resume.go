@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/generate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	resumePrompt string
+	resumeOutput string
+	resumeLoops  int
+	resumeLoop   loopOptions
+
+	resumeCmd = &cobra.Command{
+		Use:   "resume <session-dir>",
+		Short: "Continue a saved image thread with a new prompt and/or more critique loops",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			loops, settings, err := resumeLoop.settings(resumeLoops)
+			if err != nil {
+				return err
+			}
+			if strings.TrimSpace(resumePrompt) == "" && loops <= 0 {
+				return fmt.Errorf("nothing to do: pass --prompt and/or --critique-loops/--max-loops")
+			}
+			dir := args[0]
+			thread, err := ai.LoadImageThread(dir)
+			if err != nil {
+				return fmt.Errorf("failed to load session %s: %w", dir, err)
+			}
+			out := resumeOutput
+			if out == "" {
+				out = thread.Output()
+			}
+			if out == "" {
+				out = "output.png"
+			}
+			if !strings.HasSuffix(strings.ToLower(out), ".png") {
+				out += ".png"
+			}
+			if d := filepath.Dir(out); d != "." {
+				if err := os.MkdirAll(d, 0o755); err != nil {
+					return fmt.Errorf("failed to create output dir %s: %w", d, err)
+				}
+			}
+			// The latest turn becomes the current image for the next generation.
+			if img := thread.LastImage(); len(img) > 0 {
+				if err := os.WriteFile(out, img, 0o644); err != nil {
+					return err
+				}
+			}
+			thread.SetOutput(out)
+			// --var overrides the variables saved with the session; the config file and
+			// --vars-file only fill in ones it does not define.
+			vars, err := templateVars(thread.Vars())
+			if err != nil {
+				return err
+			}
+			thread.SetVars(vars)
+			fmt.Fprintf(cmd.OutOrStdout(), "Resumed session %s (%d turns, model %s)\n", dir, len(thread.Turns()), thread.Model())
+
+			ctx := context.Background()
+			loop, err := newCritiqueLoop(thread, out, dir)
+			if err != nil {
+				return err
+			}
+			loop.loopSettings = settings
+			if s := strings.TrimSpace(resumePrompt); s != "" {
+				text, err := generate.Render("prompt", s, vars)
+				if err != nil {
+					return err
+				}
+				fragTexts, err := generate.LoadFragments(thread.Fragments(), vars)
+				if err != nil {
+					return err
+				}
+				img, err := thread.AddUserMessageAndGenerate(ctx, generate.BuildEffectivePrompt(text, fragTexts), out)
+				if err != nil {
+					return fmt.Errorf("generation failed: %w", err)
+				}
+				if err := os.WriteFile(out, img, 0o644); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Generated image saved at: %s\n", out)
+				printModelText(cmd.OutOrStdout(), thread.Head())
+				if err := loop.saveSession(thread); err != nil {
+					return err
+				}
+			}
+			if loops > 0 {
+				return loop.run(ctx, cmd.OutOrStdout(), thread, loops)
+			}
+			return nil
+		},
+		Example: `nano-agent resume outputs/panel_1_session -cl 2
+nano-agent resume outputs/panel_1_session -p "Make the lighting warmer"`,
+	}
+)
+
+func init() {
+	resumeCmd.Flags().StringVarP(&resumePrompt, "prompt", "p", "", "Follow-up instruction to send before any critique loops")
+	resumeCmd.Flags().StringVarP(&resumeOutput, "output", "o", "", "Path to save the resulting PNG (default: the session's original output)")
+	resumeCmd.Flags().IntVar(&resumeLoops, "critique-loops", 0, "Number of additional critique-improve loops to run")
+	addLoopFlags(resumeCmd.Flags(), &resumeLoop)
+	rootCmd.AddCommand(resumeCmd)
+}
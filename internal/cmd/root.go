@@ -2,15 +2,14 @@ package cmd
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/cassette"
 	"github.com/rkirkendall/nano-agent/internal/generate"
 	"github.com/rkirkendall/nano-agent/internal/version"
 	"github.com/spf13/cobra"
@@ -24,15 +23,30 @@ var (
 	prompt        string
 	output        string
 	critiqueLoops int
+	rootLoop      loopOptions
 	versionFlag   bool
 	verbose       bool
 	aspectRatio   string
 	resolution    string
+	recordDir     string
+	replayDir     string
+	sessionPath   string
+	noSession     bool
+	varPairs      []string
+	varsFile      string
 
 	rootCmd = &cobra.Command{
 		Use:   "nano-agent [images...]",
 		Short: "Nano Agent — image generation and critique CLI for Gemini",
 		Long:  "Nano Agent is a cross-platform CLI that generates and iteratively improves images using Google's Gemini models with critique-improve loops.",
+		// Positional args are input images, not subcommands
+		Args: cobra.ArbitraryArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := setupProviders(); err != nil {
+				return err
+			}
+			return setupCassette()
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// --version/-v: print version and exit
 			if versionFlag {
@@ -48,104 +62,38 @@ var (
 			if strings.TrimSpace(prompt) == "" {
 				return fmt.Errorf("--prompt is required")
 			}
-			// Validate that fragments are text files, not images
-			for _, f := range fragments {
-				ext := strings.ToLower(filepath.Ext(f))
-				switch ext {
-				case ".png", ".jpg", ".jpeg", ".webp", ".gif":
-					return fmt.Errorf("--fragment expects text files; got image file: %s", f)
-				}
+			loops, settings, err := rootLoop.settings(critiqueLoops)
+			if err != nil {
+				return err
 			}
 			if output == "" {
 				output = "output.png"
 			}
-			if dir := filepath.Dir(output); dir != "." {
-				if err := os.MkdirAll(dir, 0o755); err != nil {
-					return fmt.Errorf("failed to create output dir %s: %w", dir, err)
-				}
-			}
-
-			ctx := context.Background()
-			model := viper.GetString("model")
-
-			thread, imgBytes, err := ai.StartImageThreadAndGenerate(ctx, model, images, prompt, fragments, aspectRatio, resolution)
-			if err != nil {
-				return err
-			}
 			if !strings.HasSuffix(strings.ToLower(output), ".png") {
 				output += ".png"
 			}
-			if err := os.WriteFile(output, imgBytes, 0o644); err != nil {
+			vars, err := templateVars(nil)
+			if err != nil {
 				return err
 			}
-			fmt.Fprintf(cmd.OutOrStdout(), "Generated image saved at: %s\n", output)
-
-			if critiqueLoops > 0 {
-				baseOutputPath := output
-				baseDir := filepath.Dir(baseOutputPath)
-				baseName := strings.TrimSuffix(filepath.Base(baseOutputPath), filepath.Ext(baseOutputPath))
-				outputsDir := filepath.Join(baseDir, "outputs")
-				_ = os.MkdirAll(outputsDir, 0o755)
-
-				currentImagePath := baseOutputPath
-				for i := 1; i <= critiqueLoops; i++ {
-					fmt.Fprintf(cmd.OutOrStdout(), "\n=== Critique loop %d/%d ===\n", i, critiqueLoops)
-					if verbose {
-						if b, err := os.ReadFile(currentImagePath); err == nil {
-							sum := sha256.Sum256(b)
-							fmt.Fprintf(cmd.OutOrStdout(), "Critiquing image: size=%d bytes sha256=%x\n", len(b), sum)
-						}
-					}
-					critiqueText, err := ai.GenerateCritique(ctx, model, currentImagePath, prompt, fragments, images)
-					if err != nil {
-						return fmt.Errorf("critique failed: %w", err)
-					}
-					fmt.Fprintln(cmd.OutOrStdout(), "Critique feedback:")
-					fmt.Fprintln(cmd.OutOrStdout(), critiqueText)
-
-					// Attempt to extract strict JSON actions block if present
-					actionsJSON := extractJSONActions(critiqueText)
-					var improvementPrompt string
-					if actionsJSON != "" {
-						improvementPrompt = generate.BuildImprovementPromptWithActions(prompt, critiqueText, actionsJSON)
-					} else {
-						improvementPrompt = generate.BuildImprovementPrompt(prompt, critiqueText)
-					}
-					// Re-attach fragments explicitly by composing them into the prompt each loop
-					effectivePrompt := generate.BuildEffectivePrompt(improvementPrompt, fragments)
-					if verbose {
-						fmt.Fprintf(cmd.OutOrStdout(), "Attaching %d original input images and %d fragments this iteration\n", len(images), len(fragments))
-					}
-
-					imgBytes, err := thread.AddUserMessageAndGenerate(ctx, effectivePrompt, currentImagePath)
-					if err != nil {
-						return fmt.Errorf("improvement generation failed: %w", err)
-					}
-					if err := os.WriteFile(baseOutputPath, imgBytes, 0o644); err != nil {
-						return err
-					}
-					fmt.Fprintf(cmd.OutOrStdout(), "Improved image saved at: %s\n", baseOutputPath)
-					if verbose {
-						if b2, err := os.ReadFile(baseOutputPath); err == nil {
-							sum2 := sha256.Sum256(b2)
-							fmt.Fprintf(cmd.OutOrStdout(), "Updated image: size=%d bytes sha256=%x\n", len(b2), sum2)
-						}
-					}
-					copyPath := filepath.Join(outputsDir, fmt.Sprintf("%s_improved_%d.png", baseName, i))
-					src, err := os.Open(baseOutputPath)
-					if err == nil {
-						defer src.Close()
-						if dst, err2 := os.Create(copyPath); err2 == nil {
-							_, _ = io.Copy(dst, src)
-							_ = dst.Close()
-							fmt.Fprintf(cmd.OutOrStdout(), "Iteration copy saved at: %s\n", copyPath)
-						}
-					}
-				}
+			g := generation{
+				model:       viper.GetString("model"),
+				images:      images,
+				prompt:      prompt,
+				fragments:   fragments,
+				vars:        vars,
+				aspectRatio: aspectRatio,
+				resolution:  resolution,
+				output:      output,
+				sessionDir:  resolveSessionDir(output),
+				loops:       loops,
+				settings:    settings,
 			}
-			return nil
+			_, err = g.run(context.Background(), cmd.OutOrStdout())
+			return err
 		},
-		Example: `nano-agent --prompt "Portrait..." -o output.png base.png -f fragments/a.txt --critique-loops 3 (or: -cl 3)`,
+		Example: `nano-agent --prompt "Portrait..." -o output.png base.png -f fragments/a.txt --critique-loops 3 (or: -cl 3)
+nano-agent --prompt "Portrait..." -o output.png --max-loops 5 --stop-score 8`,
 	}
 )
 
@@ -178,8 +126,39 @@ func Execute() {
 	os.Args = normalizeArgs(os.Args)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes let scripts react to why a run failed; anything else exits with 1.
+const (
+	exitNoImage        = 3
+	exitSafetyBlocked  = 4
+	exitQuota          = 5
+	exitAuth           = 6
+	exitProviderOutage = 7
+	exitNoText         = 8
+	exitRateLimited    = 9
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ai.ErrSafetyBlocked):
+		return exitSafetyBlocked
+	case errors.Is(err, ai.ErrNoImage):
+		return exitNoImage
+	case errors.Is(err, ai.ErrNoText):
+		return exitNoText
+	case errors.Is(err, ai.ErrQuota):
+		return exitQuota
+	case errors.Is(err, ai.ErrRateLimited):
+		return exitRateLimited
+	case errors.Is(err, ai.ErrAuth):
+		return exitAuth
+	case errors.Is(err, ai.ErrProviderOutage):
+		return exitProviderOutage
 	}
+	return 1
 }
 
 func init() {
@@ -187,22 +166,71 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.nano-agent.yaml)")
 	rootCmd.PersistentFlags().String("model", "gemini-3-pro-image-preview", "Model to use for generation and critique")
 	viper.BindPFlag("model", rootCmd.PersistentFlags().Lookup("model"))
+	rootCmd.PersistentFlags().String("critique-model", "", "Model to use for critique (default: the generation model), e.g. gemini/gemini-2.5-flash")
+	viper.BindPFlag("critique_model", rootCmd.PersistentFlags().Lookup("critique-model"))
+	rootCmd.PersistentFlags().String("critics", "", "Run an ensemble of critics: a count (e.g. 3) of the critique model, or a comma-separated list of models")
+	viper.BindPFlag("critics", rootCmd.PersistentFlags().Lookup("critics"))
+	rootCmd.PersistentFlags().Int("quorum", 0, "Number of critics that must flag an issue to keep it (default: majority)")
+	viper.BindPFlag("quorum", rootCmd.PersistentFlags().Lookup("quorum"))
+	rootCmd.PersistentFlags().Int("max-attempts", ai.DefaultRetryPolicy.MaxAttempts, "Tries per provider call on rate limits, 5xx errors and outage pages (1 disables retries)")
+	viper.BindPFlag("max_attempts", rootCmd.PersistentFlags().Lookup("max-attempts"))
+	rootCmd.PersistentFlags().Duration("retry-budget", ai.DefaultRetryPolicy.Budget, "Longest time to spend retrying one provider call, including waits (0 for no limit)")
+	viper.BindPFlag("retry_budget", rootCmd.PersistentFlags().Lookup("retry-budget"))
+	rootCmd.PersistentFlags().StringSlice("fallback-models", nil, "Models to try in order when generation or critique keeps failing with rate limits, timeouts or outages, e.g. openrouter/google/gemini-3-pro-image-preview")
+	viper.BindPFlag("fallback_models", rootCmd.PersistentFlags().Lookup("fallback-models"))
+	rootCmd.PersistentFlags().String("system-instruction", "", "System instruction sent with every generation and critique request")
+	viper.BindPFlag("system_instruction", rootCmd.PersistentFlags().Lookup("system-instruction"))
+	rootCmd.PersistentFlags().Float32("temperature", 0, "Sampling temperature, 0-2 (default: the model's)")
+	viper.BindPFlag("temperature", rootCmd.PersistentFlags().Lookup("temperature"))
+	rootCmd.PersistentFlags().Float32("top-p", 0, "Nucleus sampling probability, 0-1 (default: the model's)")
+	viper.BindPFlag("top_p", rootCmd.PersistentFlags().Lookup("top-p"))
+	rootCmd.PersistentFlags().Int32("seed", 0, "Sampling seed for more repeatable generations (default: random)")
+	viper.BindPFlag("seed", rootCmd.PersistentFlags().Lookup("seed"))
+	rootCmd.PersistentFlags().Int32("candidate-count", 0, "Candidates to request per image generation; the first with an image is used (default 1)")
+	viper.BindPFlag("candidate_count", rootCmd.PersistentFlags().Lookup("candidate-count"))
+	rootCmd.PersistentFlags().StringSlice("safety", nil, "Gemini safety threshold per harm category as category=threshold, e.g. dangerous=high,harassment=none")
+	viper.BindPFlag("safety_settings", rootCmd.PersistentFlags().Lookup("safety"))
+	rootCmd.PersistentFlags().StringVar(&recordDir, "record", "", "Record all provider HTTP traffic to this cassette directory")
+	rootCmd.PersistentFlags().StringVar(&replayDir, "replay", "", "Replay provider HTTP traffic from this cassette directory instead of the network")
 
 	rootCmd.Flags().StringSliceVar(&images, "images", []string{}, "Zero or more path(s) to input image files")
 	rootCmd.Flags().StringSliceVarP(&fragments, "fragment", "f", []string{}, "One or more text files to append as reusable prompt fragments")
 	rootCmd.Flags().StringVarP(&prompt, "prompt", "p", "", "Text prompt guiding the generation (required)")
 	rootCmd.Flags().StringVarP(&output, "output", "o", "output.png", "Path to save the generated PNG image")
 	rootCmd.Flags().IntVar(&critiqueLoops, "critique-loops", 0, "Number of critique-improve loops to run (default: 0)")
+	addLoopFlags(rootCmd.Flags(), &rootLoop)
 	rootCmd.Flags().BoolVarP(&versionFlag, "version", "v", false, "Print version and exit")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "V", false, "Enable verbose logging (sizes and SHA-256 per iteration)")
-	
+
+	rootCmd.PersistentFlags().StringVar(&sessionPath, "session", "", "Directory to save the thread session for later resume (default: outputs/<name>_session next to -o)")
+	rootCmd.PersistentFlags().BoolVar(&noSession, "no-session", false, "Do not save the thread session to disk")
+	rootCmd.PersistentFlags().StringArrayVar(&varPairs, "var", nil, "Template variable for prompts and fragments as key=value (repeatable), used as {{.key}}")
+	rootCmd.PersistentFlags().StringVar(&varsFile, "vars-file", "", "YAML or JSON file of template variables for prompts and fragments")
+
 	// New flags for Gemini 3
 	rootCmd.Flags().StringVar(&aspectRatio, "aspect-ratio", "", "Aspect ratio for Gemini 3 generation (e.g., '16:9', '1:1')")
 	rootCmd.Flags().StringVarP(&resolution, "resolution", "r", "", "Image resolution for Gemini 3 generation (e.g., '1K', '2K')")
 }
 
+const envPrefix = "NANO_AGENT_"
+
+// envKeys are the settings that can come from the environment, as
+// NANO_AGENT_<KEY> (e.g. NANO_AGENT_SEED). Generic names such as SEED or
+// TEMPERATURE are left to other tools; only MODEL and CRITIQUE_MODEL are also read
+// without the prefix.
+var envKeys = []string{
+	"model", "critique_model", "critics", "quorum", "max_attempts", "retry_budget", "fallback_models",
+	"system_instruction", "temperature", "top_p", "seed", "candidate_count", "safety_settings",
+}
+
 func initConfig() {
-	viper.AutomaticEnv()
+	for _, k := range envKeys {
+		names := []string{envPrefix + strings.ToUpper(k)}
+		if k == "model" || k == "critique_model" {
+			names = append(names, strings.ToUpper(k))
+		}
+		viper.BindEnv(append([]string{k}, names...)...)
+	}
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
@@ -215,34 +243,115 @@ func initConfig() {
 	_ = viper.ReadInConfig()
 }
 
-// extractJSONActions tries to locate a valid JSON object within s that
-// contains an "edits" array or "keep_notes". Returns the raw JSON substring
-// if found, otherwise an empty string.
-func extractJSONActions(s string) string {
-	s = strings.TrimSpace(s)
-	if s == "" {
+// resolveSessionDir returns the directory a run's session is saved to, or "" when
+// sessions are disabled.
+func resolveSessionDir(output string) string {
+	if noSession {
 		return ""
 	}
-	// naive scan: attempt to unmarshal balanced substrings between braces
-	bs := []byte(s)
-	for i := 0; i < len(bs); i++ {
-		if bs[i] != '{' {
-			continue
+	if sessionPath != "" {
+		return sessionPath
+	}
+	return defaultSessionDir(output)
+}
+
+// templateVars returns the template variables for a run. Later sources win: the
+// config file's vars, --vars-file, the job's own vars, then --var.
+func templateVars(job map[string]string) (map[string]string, error) {
+	var file map[string]string
+	if varsFile != "" {
+		var err error
+		if file, err = generate.LoadVars(varsFile); err != nil {
+			return nil, err
 		}
-		for j := len(bs); j > i; j-- {
-			if bs[j-1] != '}' {
-				continue
-			}
-			var m map[string]any
-			if json.Unmarshal(bs[i:j], &m) == nil {
-				if _, ok := m["edits"]; ok {
-					return string(bs[i:j])
-				}
-				if _, ok := m["keep_notes"]; ok {
-					return string(bs[i:j])
-				}
-			}
+	}
+	flags, err := generate.ParseVars(varPairs)
+	if err != nil {
+		return nil, err
+	}
+	return generate.MergeVars(viper.GetStringMapString("vars"), file, job, flags), nil
+}
+
+// setupProviders applies the retry, fallback and generation settings to every
+// provider call.
+func setupProviders() error {
+	attempts := viper.GetInt("max_attempts")
+	budget := viper.GetDuration("retry_budget")
+	if attempts < 1 {
+		return fmt.Errorf("--max-attempts must be at least 1")
+	}
+	if budget < 0 {
+		return fmt.Errorf("--retry-budget must not be negative")
+	}
+	ai.SetRetryPolicy(ai.RetryPolicy{MaxAttempts: attempts, Budget: budget})
+	// FALLBACK_MODELS and config strings arrive as one comma-separated value.
+	ai.SetFallbackModels(splitList(strings.Join(viper.GetStringSlice("fallback_models"), ",")))
+	cfg, err := generationSettings()
+	if err != nil {
+		return err
+	}
+	ai.SetGenerationDefaults(cfg)
+	return nil
+}
+
+// generationSettings reads --system-instruction, --temperature, --top-p, --seed,
+// --candidate-count and --safety (or their config keys). Sampling settings that
+// are not set are left to the model.
+func generationSettings() (ai.GenerationConfig, error) {
+	cfg := ai.GenerationConfig{
+		SystemInstruction: strings.TrimSpace(viper.GetString("system_instruction")),
+		CandidateCount:    viper.GetInt32("candidate_count"),
+	}
+	if viper.IsSet("temperature") {
+		t := float32(viper.GetFloat64("temperature"))
+		cfg.Temperature = &t
+	}
+	if viper.IsSet("top_p") {
+		p := float32(viper.GetFloat64("top_p"))
+		cfg.TopP = &p
+	}
+	if viper.IsSet("seed") {
+		s := viper.GetInt32("seed")
+		cfg.Seed = &s
+	}
+	// The config file may map categories to thresholds instead of listing pairs.
+	var pairs []string
+	if m, ok := viper.Get("safety_settings").(map[string]any); ok {
+		for k, v := range m {
+			pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(pairs)
+	} else {
+		pairs = splitList(strings.Join(viper.GetStringSlice("safety_settings"), ","))
+	}
+	for _, p := range pairs {
+		s, err := ai.ParseSafetySetting(p)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.SafetySettings = append(cfg.SafetySettings, s)
+	}
+	return cfg, cfg.Validate()
+}
+
+// setupCassette installs a recording or replaying HTTP transport for provider
+// traffic when --record or --replay is set.
+func setupCassette() error {
+	switch {
+	case recordDir != "" && replayDir != "":
+		return fmt.Errorf("--record and --replay are mutually exclusive")
+	case recordDir != "":
+		rec, err := cassette.NewRecorder(recordDir, nil)
+		if err != nil {
+			return fmt.Errorf("failed to open cassette %s: %w", recordDir, err)
+		}
+		ai.SetHTTPTransport(rec, false)
+	case replayDir != "":
+		player, err := cassette.Load(replayDir)
+		if err != nil {
+			return err
 		}
+		ai.SetHTTPTransport(player, true)
 	}
-	return ""
+	return nil
 }
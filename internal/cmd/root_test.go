@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/version"
+	"github.com/spf13/cobra"
+)
+
+func TestRootOfflineCritiqueLoop(t *testing.T) {
+	version.Version = "dev" // skip the self-update check
+	dir := t.TempDir()
+	out := filepath.Join(dir, "panel.png")
+
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetArgs([]string{"--model", "fake/test", "-p", "a lighthouse at dusk", "-o", out, "--critique-loops", "2"})
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute: %v\n%s", err, buf.String())
+	}
+	for _, p := range []string{
+		out,
+		filepath.Join(dir, "outputs", "panel_improved_1.png"),
+		filepath.Join(dir, "outputs", "panel_improved_2.png"),
+	} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s: %v", p, err)
+		}
+	}
+}
+
+func TestRootMaxLoopsStopsWhenConverged(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	out := filepath.Join(dir, "panel.png")
+
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetArgs([]string{"--model", "fake/test", "-p", "a lighthouse at dusk", "-o", out, "--max-loops", "3", "--stop-score", "5"})
+	critiqueLoops = 0 // flag values persist across Execute calls
+	defer resetFlags(rootCmd, "max-loops", "stop-score")
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute: %v\n%s", err, buf.String())
+	}
+	if !strings.Contains(buf.String(), "Converged: critique score") {
+		t.Fatalf("expected the loop to stop on score:\n%s", buf.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outputs", "panel_improved_1.png")); !os.IsNotExist(err) {
+		t.Fatalf("no improvement should run once converged (stat err: %v)", err)
+	}
+}
+
+func TestRootKeepBest(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	out := filepath.Join(dir, "panel.png")
+
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetArgs([]string{"--model", "fake/test", "-p", "a lighthouse at dusk", "-o", out, "--critique-loops", "2", "--keep", "best"})
+	defer resetFlags(rootCmd, "critique-loops", "keep")
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute: %v\n%s", err, buf.String())
+	}
+	if strings.Count(buf.String(), "Score: ") != 3 || !strings.Contains(buf.String(), "Kept best iteration: turn") {
+		t.Fatalf("expected every iteration to be scored and the best kept:\n%s", buf.String())
+	}
+}
+
+func TestRootCriticEnsemble(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	out := filepath.Join(dir, "panel.png")
+
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetArgs([]string{"--model", "fake/test", "-p", "a lighthouse at dusk", "-o", out, "--critique-loops", "1", "--critics", "3"})
+	defer resetFlags(rootCmd, "critique-loops", "critics")
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("execute: %v\n%s", err, buf.String())
+	}
+	if !strings.Contains(buf.String(), "Merged 3 critiques (quorum 2): kept 1 edits from 3 proposed") {
+		t.Fatalf("expected the three critiques to be merged:\n%s", buf.String())
+	}
+}
+
+func TestRootTemplateVars(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	out := filepath.Join(dir, "panel.png")
+	style := filepath.Join(dir, "style.txt")
+	if err := os.WriteFile(style, []byte("Draw {{.character}} looking {{.mood}}."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	vars := filepath.Join(dir, "vars.yaml")
+	if err := os.WriteFile(vars, []byte("character: Dan\nmood: calm\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer resetFlags(rootCmd, "fragment", "var", "vars-file", "session")
+
+	run := func(args ...string) (string, error) {
+		var buf bytes.Buffer
+		rootCmd.SetOut(&buf)
+		rootCmd.SetArgs(append([]string{"--model", "fake/test", "-o", out, "-f", style}, args...))
+		resetFlags(rootCmd, "var", "vars-file")
+		err := rootCmd.Execute()
+		return buf.String(), err
+	}
+	if _, err := run("-p", "a {{.place}} at dusk", "--var", "character=Dan"); err == nil || !strings.Contains(err.Error(), `undefined variable "place"`) {
+		t.Fatalf("expected a missing variable error, got %v", err)
+	}
+	session := filepath.Join(dir, "session")
+	if output, err := run("-p", "a {{.place}} at dusk", "--vars-file", vars, "--var", "place=lighthouse", "--var", "mood=tired", "--session", session); err != nil {
+		t.Fatalf("execute: %v\n%s", err, output)
+	}
+	b, err := os.ReadFile(filepath.Join(session, "session.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// --var overrides the vars file.
+	if !strings.Contains(string(b), "a lighthouse at dusk\\n\\nDraw Dan looking tired.") {
+		t.Fatalf("session does not hold the rendered prompt:\n%s", b)
+	}
+}
+
+func TestRootGenerationSettings(t *testing.T) {
+	version.Version = "dev"
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.png")
+	session := filepath.Join(dir, "session")
+	settings := []string{"system-instruction", "temperature", "top-p", "seed", "candidate-count", "safety", "session"}
+	defer resetFlags(rootCmd, settings...)
+
+	run := func(args ...string) error {
+		rootCmd.SetOut(&bytes.Buffer{})
+		rootCmd.SetArgs(append([]string{"-p", "a lighthouse", "-o", out, "--session", session}, args...))
+		resetFlags(rootCmd, settings...)
+		return rootCmd.Execute()
+	}
+	if err := run("--model", "fake/test", "--safety", "violence=high"); err == nil || !strings.Contains(err.Error(), "unknown safety category") {
+		t.Fatalf("expected an unknown category error, got %v", err)
+	}
+	if err := run("--model", "fake/test", "--temperature", "3"); err == nil || !strings.Contains(err.Error(), "temperature") {
+		t.Fatalf("expected a temperature range error, got %v", err)
+	}
+	if err := run("--model", "openrouter/google/gemini-3-pro-image-preview", "--safety", "dangerous=high"); err == nil || !strings.Contains(err.Error(), "safety settings are not supported") {
+		t.Fatalf("expected a capability error, got %v", err)
+	}
+	if err := run("--model", "fake/test", "--system-instruction", "You draw comics.", "--temperature", "0.4", "--seed", "7", "--safety", "dangerous=high,harassment=none"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(session, "session.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"system_instruction": "You draw comics."`, `"temperature": 0.4`, `"seed": 7`, `"category": "HARM_CATEGORY_DANGEROUS_CONTENT"`, `"threshold": "BLOCK_NONE"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("session config lacks %s:\n%s", want, b)
+		}
+	}
+}
+
+func TestEnvSettingsNeedPrefix(t *testing.T) {
+	t.Setenv("SEED", "7")
+	t.Setenv("TEMPERATURE", "1.5")
+	t.Setenv("NANO_AGENT_TOP_P", "0.5")
+	initConfig()
+	cfg, err := generationSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Seed != nil || cfg.Temperature != nil || cfg.TopP == nil || *cfg.TopP != 0.5 {
+		t.Fatalf("seed=%v temperature=%v top-p=%v; only prefixed variables should apply", cfg.Seed, cfg.Temperature, cfg.TopP)
+	}
+}
+
+// resetFlags restores cmd's flags to their defaults; cobra keeps parsed values
+// across Execute calls.
+func resetFlags(cmd *cobra.Command, names ...string) {
+	for _, n := range names {
+		f := cmd.Flag(n)
+		if sv, ok := f.Value.(interface{ Replace([]string) error }); ok {
+			sv.Replace(nil) // Set would append to a slice flag
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("boom"), 1},
+		{fmt.Errorf("panel 1: %w", ai.ErrNoImage), exitNoImage},
+		{&ai.NoTextError{Provider: "gemini", ResponseInfo: ai.ResponseInfo{FinishReason: "MAX_TOKENS"}}, exitNoText},
+		{&ai.SafetyBlockedError{Provider: "gemini", ResponseInfo: ai.ResponseInfo{FinishReason: "IMAGE_SAFETY"}}, exitSafetyBlocked},
+		{&ai.ProviderError{Provider: "openrouter", Class: ai.ClassBlocked, Status: 400}, exitSafetyBlocked},
+		{&ai.ProviderError{Provider: "openrouter", Class: ai.ClassQuota, Status: 402}, exitQuota},
+		{&ai.ProviderError{Provider: "gemini", Class: ai.ClassRateLimit, Status: 429}, exitRateLimited},
+		{&ai.ProviderError{Provider: "gemini", Class: ai.ClassAuth, Status: 401}, exitAuth},
+		{&ai.ProviderError{Provider: "openrouter", Class: ai.ClassOutage, Status: 503}, exitProviderOutage},
+	}
+	for _, c := range cases {
+		if got := exitCode(c.err); got != c.want {
+			t.Errorf("exitCode(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+// webpProvider returns images that imagediff cannot decode, as a provider
+// answering in WebP would.
+type webpProvider struct{}
+
+func (webpProvider) Capabilities(string) ai.Capabilities { return ai.Capabilities{} }
+func (webpProvider) StartThread(context.Context, string, []ai.Part, ai.GenerationConfig) (ai.Session, []byte, string, error) {
+	return struct{}{}, []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "", nil
+}
+func (webpProvider) ContinueThread(context.Context, ai.Session, []ai.Part) ([]byte, string, error) {
+	return []byte("RIFF\x00\x00\x00\x00WEBPVP8 next"), "", nil
+}
+func (webpProvider) Critique(context.Context, string, []ai.Part, ai.GenerationConfig) (string, error) {
+	return `{"keep_notes": [], "edits": [{"id": "sky", "target": {"type": "region", "label": "sky", "bbox": {"x": 0, "y": 0, "w": 1, "h": 0.4}}, "priority": "MAJOR", "instruction": "Brighten the sky."}]}`, nil
+}
+func (webpProvider) ForkSession(s ai.Session, _ int) (ai.Session, error) { return s, nil }
+func (webpProvider) DecodeSession([]byte) (ai.Session, error)            { return struct{}{}, nil }
+
+func TestRootLoopWithUndecodableImages(t *testing.T) {
+	version.Version = "dev"
+	ai.RegisterProvider("webp", webpProvider{})
+	defer resetFlags(rootCmd, "no-session", "mask", "guard")
+
+	for _, extra := range [][]string{nil, {"--mask", "--guard", "0.1"}} {
+		var buf bytes.Buffer
+		rootCmd.SetOut(&buf)
+		out := filepath.Join(t.TempDir(), "panel.png")
+		rootCmd.SetArgs(append([]string{"--model", "webp/test", "--no-session", "-p", "a lighthouse", "-o", out, "--critique-loops", "1"}, extra...))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("%v: %v\n%s", extra, err, buf.String())
+		}
+		skipped := strings.Contains(buf.String(), "skipping the mask and regression guard")
+		if (extra != nil) != skipped || strings.Contains(buf.String(), "Change:") {
+			t.Fatalf("%v: unexpected guard output:\n%s", extra, buf.String())
+		}
+	}
+}
+
+func TestRootRejectedIterationIsNotFollowedUp(t *testing.T) {
+	version.Version = "dev"
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	out := filepath.Join(t.TempDir(), "fox.png")
+	rootCmd.SetArgs([]string{"--model", "fake/test", "--no-session", "-p", "a red fox", "-o", out, "--critique-loops", "3", "--guard", "0.0001", "--on-regression", "reject"})
+	defer resetFlags(rootCmd, "no-session", "critique-loops", "guard", "on-regression")
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("%v\n%s", err, buf.String())
+	}
+	// Every iteration is rejected, so no critique is compared with the last one.
+	if strings.Count(buf.String(), "Rejected the iteration") != 3 || strings.Contains(buf.String(), "Edits since last critique") {
+		t.Fatalf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/compose"
+	"github.com/rkirkendall/nano-agent/internal/generate"
+	"github.com/rkirkendall/nano-agent/internal/imagediff"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	sweepPrompt       string
+	sweepImages       []string
+	sweepFragments    []string
+	sweepVars         []string
+	sweepFragmentSets []string
+	sweepAspectRatios []string
+	sweepResolutions  []string
+	sweepModels       []string
+	sweepOutput       string
+	sweepWorkers      int
+	sweepLoop         loopOptions
+
+	sweepCmd = &cobra.Command{
+		Use:   "sweep [images...]",
+		Short: "Generate every combination of variables, fragments and settings side by side",
+		Long: `Expands the cartesian product of the swept values and generates one image per
+combination with a worker pool:
+
+  --sweep-var key=a,b,c     values of a template variable (repeatable)
+  --sweep-fragments a,b     one alternative set of fragments per flag (repeatable)
+  --aspect-ratio 1:1,16:9   aspect ratios
+  --resolution 1K,2K        resolutions
+  --models m1,m2            models (default --model)
+
+Images are written to the output directory as NNN_<label>.png, where the label names
+each swept value. sweep.jsonl lists every variant and its status, and
+contact-sheet.png places the results in a grid with one column per value of the last
+swept setting, in the order sweep.jsonl lists them; contact-sheet.txt names the
+variant in each cell.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSweep(context.Background(), cmd.OutOrStdout(), append(append([]string{}, sweepImages...), args...))
+		},
+		Example: `nano-agent sweep -p "{{.character}} in the office" --sweep-var character=Dan,Barly \
+  --aspect-ratio 1:1,16:9 -f comic-style -o sweeps/office
+nano-agent sweep examples/comic/characters/dan.png -p "Dan waving" \
+  --sweep-fragments comic-style --sweep-fragments noir-style,film-grain --models gemini-3-pro-image-preview,openrouter/google/gemini-3-pro-image-preview`,
+	}
+)
+
+// sweepAxis is one swept setting and its values.
+type sweepAxis struct {
+	name   string
+	values []string
+	// short labels each value in file names.
+	short string
+}
+
+// sweepVariant is one combination of swept values.
+type sweepVariant struct {
+	Index       int               `json:"index"`
+	Label       string            `json:"label"`
+	Vars        map[string]string `json:"vars,omitempty"`
+	Fragments   []string          `json:"fragments,omitempty"`
+	AspectRatio string            `json:"aspect_ratio,omitempty"`
+	Resolution  string            `json:"resolution,omitempty"`
+	Model       string            `json:"model"`
+	Route       string            `json:"route,omitempty"` // provider/model that generated the image
+	Output      string            `json:"output"`
+	Status      string            `json:"status"` // ok or failed
+	Error       string            `json:"error,omitempty"`
+	Response    *ai.ResponseInfo  `json:"response,omitempty"` // see batchResult.Response
+	DurationMS  int64             `json:"duration_ms"`
+}
+
+// sweepAxes parses the sweep flags into axes, in product order (the last axis
+// varies fastest).
+func sweepAxes() ([]sweepAxis, error) {
+	var axes []sweepAxis
+	for _, spec := range sweepVars {
+		key, vals, ok := strings.Cut(spec, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.TrimSpace(vals) == "" {
+			return nil, fmt.Errorf("invalid --sweep-var %q: use key=value1,value2", spec)
+		}
+		axes = append(axes, sweepAxis{name: "var:" + key, short: key, values: splitList(vals)})
+	}
+	if len(sweepFragmentSets) > 0 {
+		axes = append(axes, sweepAxis{name: "fragments", short: "f", values: sweepFragmentSets})
+	}
+	if len(sweepAspectRatios) > 0 {
+		axes = append(axes, sweepAxis{name: "aspect_ratio", short: "ar", values: sweepAspectRatios})
+	}
+	if len(sweepResolutions) > 0 {
+		axes = append(axes, sweepAxis{name: "resolution", short: "res", values: sweepResolutions})
+	}
+	if len(sweepModels) > 0 {
+		axes = append(axes, sweepAxis{name: "model", short: "model", values: sweepModels})
+	}
+	return axes, nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+var unsafeLabel = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// expandSweep returns one variant per combination of axis values.
+func expandSweep(axes []sweepAxis, dir string) []sweepVariant {
+	variants := []sweepVariant{{}}
+	for _, axis := range axes {
+		next := make([]sweepVariant, 0, len(variants)*len(axis.values))
+		for _, v := range variants {
+			for _, val := range axis.values {
+				nv := v
+				nv.Vars = generate.MergeVars(v.Vars)
+				switch {
+				case strings.HasPrefix(axis.name, "var:"):
+					nv.Vars[strings.TrimPrefix(axis.name, "var:")] = val
+				case axis.name == "fragments":
+					nv.Fragments = splitList(val)
+				case axis.name == "aspect_ratio":
+					nv.AspectRatio = val
+				case axis.name == "resolution":
+					nv.Resolution = val
+				case axis.name == "model":
+					nv.Model = val
+				}
+				part := axis.short + "-" + strings.Trim(unsafeLabel.ReplaceAllString(strings.ReplaceAll(val, ":", "x"), "-"), "-")
+				nv.Label = strings.TrimPrefix(v.Label+"_"+part, "_")
+				next = append(next, nv)
+			}
+		}
+		variants = next
+	}
+	for i := range variants {
+		v := &variants[i]
+		v.Index = i + 1
+		if v.Label == "" {
+			v.Label = "base"
+		}
+		v.Output = filepath.Join(dir, fmt.Sprintf("%03d_%s.png", v.Index, v.Label))
+	}
+	return variants
+}
+
+func runSweep(ctx context.Context, out io.Writer, images []string) error {
+	if strings.TrimSpace(sweepPrompt) == "" {
+		return fmt.Errorf("--prompt is required")
+	}
+	if sweepWorkers < 1 {
+		return fmt.Errorf("--workers must be at least 1")
+	}
+	loops, settings, err := sweepLoop.settings(0)
+	if err != nil {
+		return err
+	}
+	base, err := templateVars(nil)
+	if err != nil {
+		return err
+	}
+	axes, err := sweepAxes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(sweepOutput, 0o755); err != nil {
+		return fmt.Errorf("failed to create output dir %s: %w", sweepOutput, err)
+	}
+	variants := expandSweep(axes, sweepOutput)
+	fmt.Fprintf(out, "Sweeping %d variants into %s\n", len(variants), sweepOutput)
+
+	var wg sync.WaitGroup
+	var outMu sync.Mutex
+	queue := make(chan int)
+	for w := 0; w < sweepWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				v := &variants[i]
+				var log bytes.Buffer
+				runSweepVariant(ctx, &log, v, images, base, loops, settings)
+				outMu.Lock()
+				fmt.Fprintf(out, "\n=== Variant %d/%d: %s ===\n", v.Index, len(variants), v.Label)
+				out.Write(log.Bytes())
+				outMu.Unlock()
+			}
+		}()
+	}
+	for i := range variants {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	index := filepath.Join(sweepOutput, "sweep.jsonl")
+	var lines bytes.Buffer
+	enc := json.NewEncoder(&lines)
+	failed := 0
+	for _, v := range variants {
+		if v.Status != "ok" {
+			failed++
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(index, lines.Bytes(), 0o644); err != nil {
+		return err
+	}
+	sheet, key, err := writeContactSheet(variants, axes, filepath.Join(sweepOutput, "contact-sheet.png"))
+	if err != nil {
+		return fmt.Errorf("failed to write contact sheet: %w", err)
+	}
+	fmt.Fprintf(out, "\nSweep finished: %d ok, %d failed. Index: %s, contact sheet: %s (key: %s)\n", len(variants)-failed, failed, index, sheet, key)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d variants failed", failed, len(variants))
+	}
+	return nil
+}
+
+// runSweepVariant generates one variant, logging to log, and records its status.
+func runSweepVariant(ctx context.Context, log io.Writer, v *sweepVariant, images []string, base map[string]string, loops int, settings loopSettings) {
+	start := time.Now()
+	if v.Model == "" {
+		v.Model = viper.GetString("model")
+	}
+	g := generation{
+		model:       v.Model,
+		images:      images,
+		prompt:      sweepPrompt,
+		fragments:   append(append([]string{}, sweepFragments...), v.Fragments...),
+		vars:        generate.MergeVars(base, v.Vars),
+		aspectRatio: v.AspectRatio,
+		resolution:  v.Resolution,
+		output:      v.Output,
+		loops:       loops,
+		settings:    settings,
+	}
+	if !noSession {
+		g.sessionDir = defaultSessionDir(v.Output)
+	}
+	thread, err := g.run(ctx, log)
+	if thread != nil {
+		v.Route = thread.Route()
+	}
+	v.Response = responseInfo(thread, err)
+	v.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		v.Status = "failed"
+		v.Error = err.Error()
+		fmt.Fprintf(log, "Error: %v\n", err)
+		return
+	}
+	v.Status = "ok"
+}
+
+// contactCell is the size of each contact sheet column in pixels.
+const contactCell = 512
+
+// writeContactSheet lays the variants out in a grid, one column per value of the
+// last swept axis with several values. Failed variants are shown as gray cells.
+// A .txt key next to the sheet names the variant in each cell; it returns the
+// paths of the sheet and the key.
+func writeContactSheet(variants []sweepVariant, axes []sweepAxis, path string) (string, string, error) {
+	cols := 0
+	for i := len(axes) - 1; i >= 0; i-- {
+		if len(axes[i].values) > 1 {
+			cols = len(axes[i].values)
+			break
+		}
+	}
+	if cols == 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(len(variants)))))
+	}
+	panels := make([]image.Image, 0, len(variants))
+	for _, v := range variants {
+		var img image.Image
+		if v.Status == "ok" {
+			if b, err := os.ReadFile(v.Output); err == nil {
+				img, _ = imagediff.Decode(b)
+			}
+		}
+		if img == nil {
+			img = placeholder()
+		}
+		panels = append(panels, img)
+	}
+	// Pad the last row so every column keeps the same width.
+	for len(panels)%cols != 0 {
+		panels = append(panels, nil)
+	}
+	l := compose.Layout{
+		Rows:        compose.Grid(len(panels), cols),
+		Width:       cols*contactCell + 2*16 + (cols-1)*16,
+		Margin:      16,
+		Gutter:      16,
+		Border:      1,
+		Background:  color.White,
+		BorderColor: color.Gray{Y: 160},
+	}
+	page, err := compose.Page(panels, l)
+	if err != nil {
+		return "", "", err
+	}
+	b, err := compose.EncodePNG(page, 0)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		return "", "", err
+	}
+	var key bytes.Buffer
+	fmt.Fprintf(&key, "%s: %d columns, left to right, top to bottom\n", filepath.Base(path), cols)
+	for i, v := range variants {
+		fmt.Fprintf(&key, "row %d, column %d: %s", i/cols+1, i%cols+1, filepath.Base(v.Output))
+		if v.Status != "ok" {
+			fmt.Fprint(&key, " (failed)")
+		}
+		fmt.Fprintln(&key)
+	}
+	keyPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".txt"
+	return path, keyPath, os.WriteFile(keyPath, key.Bytes(), 0o644)
+}
+
+// placeholder stands in for a variant that produced no image.
+func placeholder() image.Image {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	return img
+}
+
+func init() {
+	f := sweepCmd.Flags()
+	f.StringVarP(&sweepPrompt, "prompt", "p", "", "Text prompt for every variant (required); may use {{.var}}")
+	f.StringSliceVar(&sweepImages, "images", []string{}, "Input image files shared by every variant")
+	f.StringSliceVarP(&sweepFragments, "fragment", "f", []string{}, "Fragments applied to every variant")
+	f.StringArrayVar(&sweepVars, "sweep-var", nil, "Template variable values to sweep as key=a,b,c (repeatable)")
+	f.StringArrayVar(&sweepFragmentSets, "sweep-fragments", nil, "One alternative set of comma-separated fragments (repeat for each alternative)")
+	f.StringSliceVar(&sweepAspectRatios, "aspect-ratio", nil, "Aspect ratios to sweep, e.g. 1:1,16:9")
+	f.StringSliceVarP(&sweepResolutions, "resolution", "r", nil, "Resolutions to sweep, e.g. 1K,2K")
+	f.StringSliceVar(&sweepModels, "models", nil, "Models to sweep (default: --model)")
+	f.StringVarP(&sweepOutput, "output", "o", "sweep", "Directory for the variant images, sweep.jsonl and contact-sheet.png")
+	f.IntVarP(&sweepWorkers, "workers", "w", 2, "Number of variants to generate concurrently")
+	addLoopFlags(f, &sweepLoop)
+	rootCmd.AddCommand(sweepCmd)
+}
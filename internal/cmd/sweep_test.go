@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rkirkendall/nano-agent/internal/ai"
+	"github.com/rkirkendall/nano-agent/internal/version"
+)
+
+func TestSweepExpandsCartesianProduct(t *testing.T) {
+	version.Version = "dev"
+	dir := filepath.Join(t.TempDir(), "sweep")
+
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetArgs([]string{"sweep", "--model", "fake/test", "-p", "{{.who}} at dusk",
+		"--sweep-var", "who=a fox,an owl", "--aspect-ratio", "1:1,16:9", "--workers", "3", "-o", dir})
+	defer resetFlags(sweepCmd, "prompt", "sweep-var", "aspect-ratio", "workers", "output")
+	defer resetFlags(rootCmd, "model")
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("sweep: %v\n%s", err, buf.String())
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "sweep.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var labels, prompts []string
+	images := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var v sweepVariant
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Status != "ok" {
+			t.Fatalf("variant %s: %s", v.Label, v.Error)
+		}
+		img, err := os.ReadFile(v.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prev, ok := images[string(img)]; ok {
+			t.Fatalf("%s and %s have the same image", prev, v.Label)
+		}
+		images[string(img)] = v.Label
+		thread, err := ai.LoadImageThread(defaultSessionDir(v.Output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		labels = append(labels, filepath.Base(v.Output))
+		prompts = append(prompts, thread.Prompt())
+	}
+	want := []string{"001_who-a-fox_ar-1x1.png", "002_who-a-fox_ar-16x9.png", "003_who-an-owl_ar-1x1.png", "004_who-an-owl_ar-16x9.png"}
+	if strings.Join(labels, " ") != strings.Join(want, " ") {
+		t.Fatalf("outputs = %v, want %v", labels, want)
+	}
+	wantPrompts := []string{"a fox at dusk", "a fox at dusk", "an owl at dusk", "an owl at dusk"}
+	if strings.Join(prompts, "|") != strings.Join(wantPrompts, "|") {
+		t.Fatalf("rendered prompts = %q, want %q", prompts, wantPrompts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "contact-sheet.png")); err != nil {
+		t.Fatal(err)
+	}
+	key, err := os.ReadFile(filepath.Join(dir, "contact-sheet.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(key), "row 1, column 2: 002_who-a-fox_ar-16x9.png\nrow 2, column 1: 003_who-an-owl_ar-1x1.png\n") {
+		t.Fatalf("unexpected contact sheet key:\n%s", key)
+	}
+}
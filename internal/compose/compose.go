@@ -0,0 +1,311 @@
+// Package compose lays out finished panels on a page using only the standard
+// image packages.
+package compose
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultWidth is the page width in pixels when no page size is given; the height
+// then follows from the panels' aspect ratios.
+const DefaultWidth = 2400
+
+// Layout describes a page.
+type Layout struct {
+	// Rows lists how many panels go in each row, top to bottom. Use Grid to build
+	// it from a column count.
+	Rows []int
+	// Width and Height are the page size in pixels. With Height 0 each row is as
+	// tall as its panels need at the row's cell width.
+	Width, Height int
+	// DPI is recorded in the PNG so the page prints at the intended size (0 omits it).
+	DPI int
+	// Margin, Gutter and Border are in pixels: the page edge, the space between
+	// cells and the frame drawn around each panel.
+	Margin, Gutter, Border int
+	// Cover crops panels to fill their cells instead of fitting them inside.
+	Cover       bool
+	Background  color.Color
+	BorderColor color.Color
+}
+
+// Grid returns rows of cols panels for n panels; the last row may be shorter.
+func Grid(n, cols int) []int {
+	if cols < 1 {
+		cols = 1
+	}
+	var rows []int
+	for n > 0 {
+		k := min(cols, n)
+		rows = append(rows, k)
+		n -= k
+	}
+	return rows
+}
+
+// ParseRows parses a rows template such as "2,1,3".
+func ParseRows(s string) ([]int, error) {
+	var rows []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid rows %q: want counts like 2,1,3", s)
+		}
+		rows = append(rows, n)
+	}
+	return rows, nil
+}
+
+// named page sizes in inches.
+var pageSizes = map[string][2]float64{
+	"letter":  {8.5, 11},
+	"legal":   {8.5, 14},
+	"tabloid": {11, 17},
+	"a3":      {11.69, 16.54},
+	"a4":      {8.27, 11.69},
+	"a5":      {5.83, 8.27},
+}
+
+// ParsePageSize converts a page size to pixels at dpi. It accepts a named size
+// (letter, legal, tabloid, a3, a4, a5, optionally suffixed "-landscape"), or
+// WxH in px (default), in or mm, e.g. "2480x3508", "8.5x11in", "210x297mm".
+func ParsePageSize(s string, dpi int) (int, int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	landscape := strings.HasSuffix(s, "-landscape")
+	s = strings.TrimSuffix(s, "-landscape")
+	toPx := func(inches float64) int { return int(math.Round(inches * float64(dpi))) }
+	if size, ok := pageSizes[s]; ok {
+		if dpi <= 0 {
+			return 0, 0, fmt.Errorf("page size %q needs a DPI", s)
+		}
+		w, h := toPx(size[0]), toPx(size[1])
+		if landscape {
+			w, h = h, w
+		}
+		return w, h, nil
+	}
+	unit := 0.0 // pixels
+	switch {
+	case strings.HasSuffix(s, "in"):
+		s, unit = strings.TrimSuffix(s, "in"), 1
+	case strings.HasSuffix(s, "mm"):
+		s, unit = strings.TrimSuffix(s, "mm"), 1/25.4
+	default:
+		s = strings.TrimSuffix(s, "px")
+	}
+	ws, hs, ok := strings.Cut(s, "x")
+	w, errW := strconv.ParseFloat(strings.TrimSpace(ws), 64)
+	h, errH := strconv.ParseFloat(strings.TrimSpace(hs), 64)
+	if !ok || errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid page size %q: use a name like a4 or WxH[px|in|mm]", s)
+	}
+	if unit == 0 {
+		return int(w), int(h), nil
+	}
+	if dpi <= 0 {
+		return 0, 0, fmt.Errorf("page size %q needs a DPI", s)
+	}
+	return toPx(w * unit), toPx(h * unit), nil
+}
+
+// ParseColor parses #rgb, #rrggbb or a few names (white, black, transparent).
+func ParseColor(s string) (color.Color, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "white":
+		return color.White, nil
+	case "black":
+		return color.Black, nil
+	case "transparent", "none":
+		return color.Transparent, nil
+	}
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q: use #rrggbb", s)
+	}
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// Page composes panels, in reading order, into one image. A nil panel leaves its
+// cell empty.
+func Page(panels []image.Image, l Layout) (*image.NRGBA, error) {
+	total := 0
+	for _, n := range l.Rows {
+		total += n
+	}
+	if len(panels) == 0 {
+		return nil, errors.New("no panels to compose")
+	}
+	if total != len(panels) {
+		return nil, fmt.Errorf("layout has %d cells but there are %d panels", total, len(panels))
+	}
+	width := l.Width
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	inner := width - 2*l.Margin
+
+	// Cell widths per row, and row heights.
+	cellW := make([]int, len(l.Rows))
+	rowH := make([]int, len(l.Rows))
+	i := 0
+	for r, n := range l.Rows {
+		cellW[r] = (inner - (n-1)*l.Gutter) / n
+		if cellW[r] <= 2*l.Border {
+			return nil, fmt.Errorf("row %d: %d panels do not fit in a %dpx page", r+1, n, width)
+		}
+		for _, p := range panels[i : i+n] {
+			if p == nil {
+				continue
+			}
+			b := p.Bounds()
+			rowH[r] = max(rowH[r], int(math.Round(float64(cellW[r])*float64(b.Dy())/float64(b.Dx()))))
+		}
+		i += n
+	}
+	height := l.Height
+	if height > 0 {
+		h := (height - 2*l.Margin - (len(l.Rows)-1)*l.Gutter) / len(l.Rows)
+		if h <= 2*l.Border {
+			return nil, fmt.Errorf("%d rows do not fit in a %dpx page", len(l.Rows), height)
+		}
+		for r := range rowH {
+			rowH[r] = h
+		}
+	} else {
+		height = 2*l.Margin + (len(l.Rows)-1)*l.Gutter
+		for _, h := range rowH {
+			height += h
+		}
+	}
+
+	bg := l.Background
+	if bg == nil {
+		bg = color.White
+	}
+	frame := l.BorderColor
+	if frame == nil {
+		frame = color.Black
+	}
+	page := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(page, page.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	y := l.Margin
+	i = 0
+	for r, n := range l.Rows {
+		x := l.Margin
+		for range n {
+			cell := image.Rect(x, y, x+cellW[r], y+rowH[r])
+			if panels[i] != nil {
+				placePanel(page, panels[i], cell, l.Border, l.Cover, frame)
+			}
+			x += cellW[r] + l.Gutter
+			i++
+		}
+		y += rowH[r] + l.Gutter
+	}
+	return page, nil
+}
+
+// placePanel draws p into cell, framed by a border of the given width. The panel
+// is fitted inside the cell (or cropped to cover it) and centered.
+func placePanel(page *image.NRGBA, p image.Image, cell image.Rectangle, border int, cover bool, frame color.Color) {
+	inside := cell.Inset(border)
+	src := p.Bounds()
+	scale := math.Min(float64(inside.Dx())/float64(src.Dx()), float64(inside.Dy())/float64(src.Dy()))
+	if cover {
+		scale = math.Max(float64(inside.Dx())/float64(src.Dx()), float64(inside.Dy())/float64(src.Dy()))
+	}
+	w, h := int(math.Round(float64(src.Dx())*scale)), int(math.Round(float64(src.Dy())*scale))
+	dst := image.Rect(0, 0, w, h).Add(inside.Min).Add(image.Pt((inside.Dx()-w)/2, (inside.Dy()-h)/2))
+	scaled := scale2(p, w, h)
+	visible := dst.Intersect(inside)
+	draw.Draw(page, visible, scaled, visible.Min.Sub(dst.Min), draw.Over)
+	if border > 0 {
+		outer := visible.Inset(-border)
+		fill := image.NewUniform(frame)
+		for _, edge := range []image.Rectangle{
+			image.Rect(outer.Min.X, outer.Min.Y, outer.Max.X, visible.Min.Y),
+			image.Rect(outer.Min.X, visible.Max.Y, outer.Max.X, outer.Max.Y),
+			image.Rect(outer.Min.X, visible.Min.Y, visible.Min.X, visible.Max.Y),
+			image.Rect(visible.Max.X, visible.Min.Y, outer.Max.X, visible.Max.Y),
+		} {
+			draw.Draw(page, edge, fill, image.Point{}, draw.Src)
+		}
+	}
+}
+
+// scale2 resizes src to w×h with bilinear sampling, which is enough for
+// downscaling generated panels by the modest factors a page needs.
+func scale2(src image.Image, w, h int) *image.NRGBA {
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	sb := src.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, sb.Dx(), sb.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), src, sb.Min, draw.Src)
+	sx := float64(sb.Dx()) / float64(w)
+	sy := float64(sb.Dy()) / float64(h)
+	for y := 0; y < h; y++ {
+		fy := math.Max(0, (float64(y)+0.5)*sy-0.5)
+		y0 := min(int(fy), sb.Dy()-1)
+		y1 := min(y0+1, sb.Dy()-1)
+		ty := fy - float64(y0)
+		for x := 0; x < w; x++ {
+			fx := math.Max(0, (float64(x)+0.5)*sx-0.5)
+			x0 := min(int(fx), sb.Dx()-1)
+			x1 := min(x0+1, sb.Dx()-1)
+			tx := fx - float64(x0)
+			o := out.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				p00 := float64(nrgba.Pix[nrgba.PixOffset(x0, y0)+c])
+				p10 := float64(nrgba.Pix[nrgba.PixOffset(x1, y0)+c])
+				p01 := float64(nrgba.Pix[nrgba.PixOffset(x0, y1)+c])
+				p11 := float64(nrgba.Pix[nrgba.PixOffset(x1, y1)+c])
+				top := p00 + (p10-p00)*tx
+				bot := p01 + (p11-p01)*tx
+				out.Pix[o+c] = uint8(math.Round(top + (bot-top)*ty))
+			}
+		}
+	}
+	return out
+}
+
+// EncodePNG encodes img, recording dpi in a pHYs chunk when it is positive.
+func EncodePNG(img image.Image, dpi int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	if dpi <= 0 {
+		return b, nil
+	}
+	// pHYs must come before the image data; insert it right after IHDR, which is
+	// always the first chunk (8-byte signature + 25-byte IHDR chunk).
+	const afterIHDR = 8 + 25
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	chunk := make([]byte, 0, 21)
+	chunk = binary.BigEndian.AppendUint32(chunk, 9)
+	chunk = append(chunk, "pHYs"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = binary.BigEndian.AppendUint32(chunk, ppm)
+	chunk = append(chunk, 1) // unit: metre
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	out := make([]byte, 0, len(b)+len(chunk))
+	out = append(out, b[:afterIHDR]...)
+	out = append(out, chunk...)
+	return append(out, b[afterIHDR:]...), nil
+}
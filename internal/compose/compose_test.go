@@ -0,0 +1,86 @@
+package compose
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func solid(w, h int, c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestPageRowsLayout(t *testing.T) {
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	panels := []image.Image{solid(160, 90, red), solid(100, 100, blue), solid(100, 100, blue)}
+	rows, err := ParseRows("1,2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := Page(panels, Layout{Rows: rows, Width: 420, Margin: 10, Gutter: 20, Border: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Row 1: one 400px cell, 225px tall; row 2: two 190px square cells.
+	if got, want := page.Bounds().Dy(), 10+225+20+190+10; got != want {
+		t.Fatalf("page height = %d, want %d", got, want)
+	}
+	for _, c := range []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{5, 5, color.NRGBA{255, 255, 255, 255}},     // margin
+		{11, 11, color.NRGBA{0, 0, 0, 255}},         // border
+		{200, 100, red},                             // first panel
+		{100, 350, blue},                            // second panel
+		{215, 350, color.NRGBA{255, 255, 255, 255}}, // gutter
+		{320, 350, blue},                            // third panel
+	} {
+		if got := page.NRGBAAt(c.x, c.y); got != c.want {
+			t.Errorf("pixel (%d,%d) = %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+
+	if _, err := Page(panels, Layout{Rows: Grid(2, 2)}); err == nil {
+		t.Fatal("expected an error for a layout with too few cells")
+	}
+}
+
+func TestParsePageSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		w, h int
+	}{
+		{"letter", 2550, 3300},
+		{"a4-landscape", 3507, 2481},
+		{"210x297mm", 2480, 3508},
+		{"8.5x11in", 2550, 3300},
+		{"1200x800", 1200, 800},
+	}
+	for _, c := range cases {
+		w, h, err := ParsePageSize(c.in, 300)
+		if err != nil || w != c.w || h != c.h {
+			t.Errorf("ParsePageSize(%q) = %d, %d, %v; want %d, %d", c.in, w, h, err, c.w, c.h)
+		}
+	}
+	if _, _, err := ParsePageSize("huge", 300); err == nil {
+		t.Error("expected an error for an unknown size")
+	}
+}
+
+func TestEncodePNGRecordsDPI(t *testing.T) {
+	b, err := EncodePNG(solid(4, 4, color.White), 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte("pHYs")) {
+		t.Fatal("no pHYs chunk")
+	}
+	if _, _, err := image.Decode(bytes.NewReader(b)); err != nil {
+		t.Fatalf("page does not decode: %v", err)
+	}
+}
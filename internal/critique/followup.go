@@ -1,13 +1,136 @@
 package critique
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
-// EscalateUnresolved is a placeholder that would analyze previous vs current
-// critique results and return emphasized directives for unresolved items.
-func EscalateUnresolved(previous, current string) string {
-	s := strings.TrimSpace(previous + "\n\n" + current)
-	if s == "" {
-		return ""
+// Status says how an edit relates to the previous iteration's critique.
+type Status string
+
+const (
+	// StatusNew marks an edit the previous critique did not ask for.
+	StatusNew Status = "new"
+	// StatusPersisting marks an edit that was requested before and is still needed.
+	StatusPersisting Status = "persisting"
+	// StatusResolved marks a previous edit the current critique no longer asks for.
+	StatusResolved Status = "resolved"
+)
+
+// PersistedTag prefixes escalated edits in improvement prompts; the improvement
+// prompt tells the model to handle these first.
+const PersistedTag = "[CRITICAL — persisted]"
+
+// minOverlap is the intersection-over-union above which two boxes of the same
+// target type are treated as the same edit.
+const minOverlap = 0.3
+
+// Tracked is an edit with its status. Previous is the matching edit from the
+// previous critique for persisting edits; resolved entries carry the previous edit
+// itself in Edit.
+type Tracked struct {
+	Edit     Edit
+	Status   Status
+	Previous *Edit
+}
+
+// Compare matches the current critique's edits against the previous one by
+// target label or bounding-box overlap. Each previous edit matches at most once.
+// Current edits come first in their original order, followed by resolved edits.
+func Compare(previous, current *Result) []Tracked {
+	var prev, cur []Edit
+	if previous != nil {
+		prev = previous.Edits
+	}
+	if current != nil {
+		cur = current.Edits
+	}
+	used := make([]bool, len(prev))
+	out := make([]Tracked, 0, len(cur)+len(prev))
+	for _, e := range cur {
+		t := Tracked{Edit: e, Status: StatusNew}
+		for i := range prev {
+			if !used[i] && sameEdit(prev[i], e) {
+				used[i] = true
+				t.Status = StatusPersisting
+				t.Previous = &prev[i]
+				break
+			}
+		}
+		out = append(out, t)
+	}
+	for i, e := range prev {
+		if !used[i] {
+			out = append(out, Tracked{Edit: e, Status: StatusResolved})
+		}
+	}
+	return out
+}
+
+// sameEdit reports whether two edits refer to the same target. Ids are not
+// compared: each critique is a fresh request, so a critic may reuse an id such
+// as "edit-1" for an unrelated target.
+func sameEdit(a, b Edit) bool {
+	la := strings.ToLower(strings.TrimSpace(a.Target.Label))
+	if la != "" && la == strings.ToLower(strings.TrimSpace(b.Target.Label)) {
+		return true
+	}
+	if a.Target.Type == b.Target.Type && a.Target.BBox != nil && b.Target.BBox != nil {
+		return iou(*a.Target.BBox, *b.Target.BBox) >= minOverlap
+	}
+	return false
+}
+
+func iou(a, b BBox) float64 {
+	w := min(a.X+a.W, b.X+b.W) - max(a.X, b.X)
+	h := min(a.Y+a.H, b.Y+b.H) - max(a.Y, b.Y)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	inter := w * h
+	union := a.W*a.H + b.W*b.H - inter
+	if union <= 0 {
+		return 0
+	}
+	return inter / union
+}
+
+// EscalateUnresolved compares the previous iteration's critique with the current
+// one and returns directives for the next improvement prompt: edits that persisted
+// are restated under PersistedTag and resolved ones are listed so they are not
+// undone. It returns "" when there is nothing to escalate or protect.
+func EscalateUnresolved(previous, current *Result) string {
+	var persisting, resolved []string
+	for _, t := range Compare(previous, current) {
+		switch t.Status {
+		case StatusPersisting:
+			line := fmt.Sprintf("- %s %s: %s", PersistedTag, label(t.Edit), strings.TrimSpace(t.Edit.Instruction))
+			if d := strings.TrimSpace(t.Edit.DoneWhen); d != "" {
+				line += " Done when: " + d
+			}
+			persisting = append(persisting, line)
+		case StatusResolved:
+			resolved = append(resolved, "- "+label(t.Edit))
+		}
+	}
+	var b strings.Builder
+	if len(persisting) > 0 {
+		b.WriteString("These edits were requested last iteration and are still not fixed. Apply them decisively before anything else:\n")
+		b.WriteString(strings.Join(persisting, "\n"))
+	}
+	if len(resolved) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString("Resolved since last iteration (done; do not regress):\n")
+		b.WriteString(strings.Join(resolved, "\n"))
+	}
+	return b.String()
+}
+
+func label(e Edit) string {
+	if l := strings.TrimSpace(e.Target.Label); l != "" {
+		return l
 	}
-	return "[CRITICAL — persisted] Fix unresolved items with imperative directives.\n\n" + s
+	return e.ID
 }
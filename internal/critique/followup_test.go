@@ -1,12 +1,57 @@
 package critique
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareAndEscalate(t *testing.T) {
+	prev := &Result{Edits: []Edit{
+		{ID: "eyes", Target: Target{Type: "object", Label: "eyes"}, Priority: PriorityMajor, Instruction: "Make the eyes symmetric."},
+		{ID: "halo", Target: Target{Type: "region", Label: "head outline", BBox: &BBox{X: 0.4, Y: 0.1, W: 0.2, H: 0.2}}, Priority: PriorityMinor, Instruction: "Remove haloing."},
+		{ID: "sky", Target: Target{Type: "region", Label: "sky"}, Priority: PriorityMinor, Instruction: "Brighten the sky."},
+	}}
+	cur := &Result{Edits: []Edit{
+		{ID: "e1", Target: Target{Type: "object", Label: "Eyes"}, Priority: PriorityMajor, Instruction: "Eyes are still asymmetric; mirror the left eye.", DoneWhen: "Both irises match."},
+		{ID: "e2", Target: Target{Type: "region", Label: "halo", BBox: &BBox{X: 0.42, Y: 0.12, W: 0.2, H: 0.2}}, Priority: PriorityMinor, Instruction: "Halo remains around the head."},
+		{ID: "e3", Target: Target{Type: "object", Label: "hands"}, Priority: PriorityMajor, Instruction: "Fix the fingers."},
+	}}
+
+	got := map[string]Status{}
+	for _, tr := range Compare(prev, cur) {
+		got[tr.Edit.ID] = tr.Status
+	}
+	want := map[string]Status{"e1": StatusPersisting, "e2": StatusPersisting, "e3": StatusNew, "sky": StatusResolved}
+	for id, s := range want {
+		if got[id] != s {
+			t.Errorf("%s: status %q, want %q", id, got[id], s)
+		}
+	}
 
-func TestEscalateUnresolved(t *testing.T) {
-	prev := "- [ ] fix eyes symmetry\n- [ ] remove haloing around head"
-	cur := "eyes still asymmetric; halo remains"
 	out := EscalateUnresolved(prev, cur)
-	if out == "" {
-		t.Fatal("expected non-empty escalation output")
+	if strings.Count(out, PersistedTag) != 2 || !strings.Contains(out, "Both irises match.") {
+		t.Fatalf("expected two escalated edits, got:\n%s", out)
+	}
+	if strings.Contains(out, "Fix the fingers") || !strings.Contains(out, "- sky") {
+		t.Fatalf("new edits must not be escalated and resolved ones must be listed:\n%s", out)
+	}
+	if EscalateUnresolved(nil, cur) != "" {
+		t.Fatal("first iteration has nothing to escalate")
+	}
+}
+
+func TestCompareIgnoresIDs(t *testing.T) {
+	prev := &Result{Edits: []Edit{
+		{ID: "edit-1", Target: Target{Type: "region", Label: "sky", BBox: &BBox{X: 0, Y: 0, W: 1, H: 0.3}}, Priority: PriorityMinor, Instruction: "Brighten the sky."},
+	}}
+	cur := &Result{Edits: []Edit{
+		{ID: "edit-1", Target: Target{Type: "object", Label: "hands", BBox: &BBox{X: 0.4, Y: 0.6, W: 0.2, H: 0.2}}, Priority: PriorityMajor, Instruction: "Fix the fingers."},
+	}}
+	tracked := Compare(prev, cur)
+	if len(tracked) != 2 || tracked[0].Status != StatusNew || tracked[1].Status != StatusResolved {
+		t.Fatalf("the same id on different targets must not match: %+v", tracked)
+	}
+	if out := EscalateUnresolved(prev, cur); strings.Contains(out, PersistedTag) {
+		t.Fatalf("nothing persisted, got:\n%s", out)
 	}
 }
@@ -0,0 +1,159 @@
+package critique
+
+import (
+	"sort"
+	"strings"
+)
+
+// severity orders priorities for voting ties and sorting; higher is more severe.
+var severity = map[Priority]int{PriorityMinor: 1, PriorityMajor: 2, PriorityCritical: 3}
+
+// cluster is one issue as reported by one or more critics.
+type cluster struct {
+	edits  []Edit
+	critic map[int]bool
+}
+
+func (c *cluster) matches(e Edit) bool {
+	for _, m := range c.edits {
+		if sameEdit(m, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge combines several critics' results into one. Edits that refer to the same
+// target (by label or bounding-box overlap; critics number their ids
+// independently) are grouped, and a group is kept
+// only when at least quorum critics flagged it. Its priority is the one most
+// critics chose, ties going to the more severe, and the first critic's wording for
+// that priority is kept. Notes are unioned and scores averaged. Nil results (a
+// critic that failed) are skipped; Merge returns nil when none remain.
+func Merge(results []*Result, quorum int) *Result {
+	var valid []*Result
+	for _, r := range results {
+		if r != nil {
+			valid = append(valid, r)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	if quorum < 1 {
+		quorum = 1
+	}
+
+	var clusters []*cluster
+	for i, r := range valid {
+		for _, e := range r.Edits {
+			var into *cluster
+			for _, c := range clusters {
+				if !c.critic[i] && c.matches(e) {
+					into = c
+					break
+				}
+			}
+			if into == nil {
+				into = &cluster{critic: map[int]bool{}}
+				clusters = append(clusters, into)
+			}
+			into.edits = append(into.edits, e)
+			into.critic[i] = true
+		}
+	}
+
+	merged := &Result{}
+	for _, c := range clusters {
+		if len(c.critic) < quorum {
+			continue
+		}
+		merged.Edits = append(merged.Edits, c.consensus())
+	}
+	sort.SliceStable(merged.Edits, func(i, j int) bool {
+		return severity[merged.Edits[i].Priority] > severity[merged.Edits[j].Priority]
+	})
+	if len(merged.Edits) > MaxEdits {
+		merged.Edits = merged.Edits[:MaxEdits]
+	}
+	for _, r := range valid {
+		merged.KeepNotes = union(merged.KeepNotes, r.KeepNotes)
+		merged.SummaryKeep = union(merged.SummaryKeep, r.SummaryKeep)
+		merged.SummaryChange = union(merged.SummaryChange, r.SummaryChange)
+	}
+	merged.Score, merged.Scores = averageRatings(valid)
+	return merged
+}
+
+func (c *cluster) consensus() Edit {
+	votes := map[Priority]int{}
+	for _, e := range c.edits {
+		votes[e.Priority]++
+	}
+	var winner Priority
+	for p, n := range votes {
+		if n > votes[winner] || (n == votes[winner] && severity[p] > severity[winner]) {
+			winner = p
+		}
+	}
+	for _, e := range c.edits {
+		if e.Priority == winner {
+			return e
+		}
+	}
+	return c.edits[0]
+}
+
+func union(dst, src []string) []string {
+	seen := map[string]bool{}
+	for _, s := range dst {
+		seen[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+	for _, s := range src {
+		k := strings.ToLower(strings.TrimSpace(s))
+		if k == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		dst = append(dst, s)
+	}
+	return dst
+}
+
+// averageRatings averages the overall score and each rubric criterion over the
+// critics that reported them.
+func averageRatings(results []*Result) (*float64, *Scores) {
+	var total float64
+	var rated int
+	var pa, art, ref float64
+	var scored, refs int
+	for _, r := range results {
+		if rt := r.Rating(); rt != nil {
+			total += rt.Score
+			rated++
+		}
+		if s := r.Scores; s != nil {
+			pa += s.PromptAdherence
+			art += s.Artifacts
+			scored++
+			if s.ReferenceFidelity != nil {
+				ref += *s.ReferenceFidelity
+				refs++
+			}
+		}
+	}
+	var score *float64
+	if rated > 0 {
+		v := total / float64(rated)
+		score = &v
+	}
+	var scores *Scores
+	if scored > 0 {
+		scores = &Scores{PromptAdherence: pa / float64(scored), Artifacts: art / float64(scored)}
+		if refs > 0 {
+			v := ref / float64(refs)
+			scores.ReferenceFidelity = &v
+		}
+	}
+	return score, scores
+}
@@ -0,0 +1,55 @@
+package critique
+
+import "testing"
+
+func TestMergeKeepsQuorumIssues(t *testing.T) {
+	score := func(v float64) *float64 { return &v }
+	hands := func(p Priority) Edit {
+		return Edit{ID: "hands", Target: Target{Type: "object", Label: "hands"}, Priority: p, Instruction: "Fix the fingers."}
+	}
+	critics := []*Result{
+		{Score: score(6), KeepNotes: []string{"pose"}, Edits: []Edit{
+			hands(PriorityMajor),
+			{ID: "ghost", Target: Target{Type: "region", Label: "ghost in window", BBox: &BBox{X: 0.8, Y: 0.1, W: 0.1, H: 0.1}}, Priority: PriorityCritical, Instruction: "Remove the ghost."},
+		}},
+		{Score: score(8), KeepNotes: []string{"Pose", "palette"}, Edits: []Edit{
+			hands(PriorityCritical),
+			{ID: "sky", Target: Target{Type: "region", Label: "sky", BBox: &BBox{X: 0, Y: 0, W: 1, H: 0.3}}, Priority: PriorityMinor, Instruction: "Brighten the sky."},
+		}},
+		nil, // a critic that failed
+		{Score: score(7), Edits: []Edit{
+			hands(PriorityMajor),
+			{ID: "clouds", Target: Target{Type: "region", Label: "clouds", BBox: &BBox{X: 0.05, Y: 0, W: 0.9, H: 0.3}}, Priority: PriorityMinor, Instruction: "Soften the clouds."},
+		}},
+	}
+
+	m := Merge(critics, 2)
+	if len(m.Edits) != 2 {
+		t.Fatalf("expected hands and sky/clouds to survive the quorum, got %+v", m.Edits)
+	}
+	if m.Edits[0].ID != "hands" || m.Edits[0].Priority != PriorityMajor {
+		t.Errorf("hands should win MAJOR by 2 votes to 1, got %+v", m.Edits[0])
+	}
+	if m.Edits[1].Priority != PriorityMinor {
+		t.Errorf("overlapping sky edits should merge, got %+v", m.Edits[1])
+	}
+	if len(m.KeepNotes) != 2 || m.Score == nil || *m.Score != 7 {
+		t.Errorf("notes=%v score=%v", m.KeepNotes, m.Score)
+	}
+	if Merge([]*Result{nil}, 1) != nil {
+		t.Error("expected nil when every critic failed")
+	}
+}
+
+func TestMergeIgnoresIDs(t *testing.T) {
+	critics := []*Result{
+		{Edits: []Edit{{ID: "edit-1", Target: Target{Type: "object", Label: "hands", BBox: &BBox{X: 0.4, Y: 0.6, W: 0.2, H: 0.2}}, Priority: PriorityMajor, Instruction: "Fix the fingers."}}},
+		{Edits: []Edit{{ID: "edit-1", Target: Target{Type: "region", Label: "sky", BBox: &BBox{X: 0, Y: 0, W: 1, H: 0.3}}, Priority: PriorityMinor, Instruction: "Brighten the sky."}}},
+	}
+	if m := Merge(critics, 2); len(m.Edits) != 0 {
+		t.Fatalf("critics that disagree must not reach quorum through a shared id, got %+v", m.Edits)
+	}
+	if m := Merge(critics, 1); len(m.Edits) != 2 {
+		t.Fatalf("expected both edits kept separately, got %+v", m.Edits)
+	}
+}
@@ -10,6 +10,8 @@ func BuildCritiqueInstruction() string {
 	b.WriteString("You are an expert image QA reviewer. Given the latest generated image (not the original), the original prompt, and any input reference images, return ONLY a single valid JSON object describing exactly what to KEEP and what to CHANGE next. No prose outside JSON.\n\n")
 	b.WriteString("Use this exact schema:\n\n")
 	b.WriteString("{")
+	b.WriteString("\n  \"score\": number,")
+	b.WriteString("\n  \"scores\": { \"prompt_adherence\": number, \"artifacts\": number, \"reference_fidelity\": number | null },")
 	b.WriteString("\n  \"keep_notes\": [string, ...],")
 	b.WriteString("\n  \"summary_keep\": [string, ...],")
 	b.WriteString("\n  \"summary_change\": [string, ...],")
@@ -19,5 +21,8 @@ func BuildCritiqueInstruction() string {
 	b.WriteString("- No text outside the JSON object.\n")
 	b.WriteString("- Coordinates normalized 0-1 relative to image width/height.\n")
 	b.WriteString("- Max 8 edits; prioritize CRITICAL then MAJOR then MINOR.\n")
+	b.WriteString("- score rates the image as it is now from 0 (unusable) to 10 (nothing left to change).\n")
+	b.WriteString("- scores rate the same image 0-10 per rubric criterion, higher is better: prompt_adherence (every element of the prompt is present and correct), artifacts (10 = no anatomy errors, warped text, seams or haloing), reference_fidelity (characters, places and style match the input reference images; null when there are none).\n")
+	b.WriteString("- Use CRITICAL or MAJOR only for problems worth another generation; an image needing no such edits is finished.\n")
 	return b.String()
 }
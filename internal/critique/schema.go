@@ -0,0 +1,270 @@
+package critique
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxEdits is the largest number of edits a critique may request in one iteration.
+const MaxEdits = 8
+
+// MaxScore is the top of the critic's 0-10 quality scale.
+const MaxScore = 10
+
+// Priority ranks an edit. Items are applied CRITICAL first, then MAJOR, then MINOR.
+type Priority string
+
+const (
+	PriorityCritical Priority = "CRITICAL"
+	PriorityMajor    Priority = "MAJOR"
+	PriorityMinor    Priority = "MINOR"
+)
+
+// ErrInvalid is wrapped by every error returned from Parse, so callers can tell a
+// malformed critique apart from a failed request.
+var ErrInvalid = errors.New("invalid critique")
+
+// Result is the JSON object described by BuildCritiqueInstruction.
+type Result struct {
+	// Score is the critic's overall 0-10 rating of the image, when given.
+	Score *float64 `json:"score,omitempty"`
+	// Scores breaks the rating down by rubric criterion.
+	Scores        *Scores  `json:"scores,omitempty"`
+	KeepNotes     []string `json:"keep_notes"`
+	SummaryKeep   []string `json:"summary_keep"`
+	SummaryChange []string `json:"summary_change"`
+	Edits         []Edit   `json:"edits"`
+}
+
+// Scores rates an image 0-10 against the critique rubric. Higher is better for
+// every criterion, so a high Artifacts score means few artifacts.
+type Scores struct {
+	PromptAdherence float64 `json:"prompt_adherence"`
+	Artifacts       float64 `json:"artifacts"`
+	// ReferenceFidelity is nil when no reference images were given.
+	ReferenceFidelity *float64 `json:"reference_fidelity"`
+}
+
+// mean averages the criteria that were scored.
+func (s *Scores) mean() float64 {
+	sum, n := s.PromptAdherence+s.Artifacts, 2.0
+	if s.ReferenceFidelity != nil {
+		sum += *s.ReferenceFidelity
+		n++
+	}
+	return sum / n
+}
+
+func (s *Scores) String() string {
+	out := fmt.Sprintf("prompt adherence %g, artifacts %g", s.PromptAdherence, s.Artifacts)
+	if s.ReferenceFidelity != nil {
+		out += fmt.Sprintf(", reference fidelity %g", *s.ReferenceFidelity)
+	}
+	return out
+}
+
+// Rating is the score recorded for one iteration's image.
+type Rating struct {
+	Score  float64 `json:"score"`
+	Scores *Scores `json:"scores,omitempty"`
+}
+
+func (r *Rating) String() string {
+	if r.Scores == nil {
+		return fmt.Sprintf("%.1f/%d", r.Score, MaxScore)
+	}
+	return fmt.Sprintf("%.1f/%d (%s)", r.Score, MaxScore, r.Scores)
+}
+
+// Rating returns the critique's rating, or nil when it has neither an overall
+// score nor rubric scores. Without an overall score the rubric mean is used.
+func (r *Result) Rating() *Rating {
+	switch {
+	case r.Score != nil:
+		return &Rating{Score: *r.Score, Scores: r.Scores}
+	case r.Scores != nil:
+		return &Rating{Score: r.Scores.mean(), Scores: r.Scores}
+	}
+	return nil
+}
+
+// Edit is one actionable change requested by the critic.
+type Edit struct {
+	ID          string   `json:"id"`
+	Target      Target   `json:"target"`
+	Priority    Priority `json:"priority"`
+	Instruction string   `json:"instruction"`
+	Rationale   string   `json:"rationale,omitempty"`
+	DoneWhen    string   `json:"done_when,omitempty"`
+}
+
+// Target locates an edit in the image. BBox and Points use coordinates normalized
+// to 0-1 relative to the image width and height.
+type Target struct {
+	Type   string  `json:"type"`
+	Label  string  `json:"label"`
+	BBox   *BBox   `json:"bbox"`
+	Points []Point `json:"points"`
+}
+
+// BBox is a normalized bounding box with its origin at the top-left corner.
+type BBox struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+// Point is a normalized image coordinate.
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Parse extracts the critique object from a model response and validates it.
+// Markdown code fences and prose before or after the object are ignored. Edits are
+// normalized (priorities upper-cased, missing ids filled in) before validation.
+func Parse(text string) (*Result, error) {
+	raw := findObject(text)
+	if raw == nil {
+		return nil, fmt.Errorf("%w: no JSON object with \"edits\" or \"keep_notes\" found", ErrInvalid)
+	}
+	var r Result
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	if err := dec.Decode(&r); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	r.normalize()
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// findObject returns the first JSON object in text that has an "edits" or
+// "keep_notes" key. Each candidate is decoded once from its opening brace, and the
+// decoder stops at the end of the value, so trailing prose is never scanned twice.
+func findObject(text string) json.RawMessage {
+	for i := strings.IndexByte(text, '{'); i >= 0; {
+		var m map[string]json.RawMessage
+		dec := json.NewDecoder(strings.NewReader(text[i:]))
+		if err := dec.Decode(&m); err == nil {
+			_, hasEdits := m["edits"]
+			_, hasKeep := m["keep_notes"]
+			if hasEdits || hasKeep {
+				return json.RawMessage(text[i : i+int(dec.InputOffset())])
+			}
+		}
+		next := strings.IndexByte(text[i+1:], '{')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return nil
+}
+
+// Blocking returns the number of CRITICAL and MAJOR edits, the ones worth another
+// generation.
+func (r *Result) Blocking() int {
+	n := 0
+	for _, e := range r.Edits {
+		if e.Priority == PriorityCritical || e.Priority == PriorityMajor {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Result) normalize() {
+	for i := range r.Edits {
+		e := &r.Edits[i]
+		e.ID = strings.TrimSpace(e.ID)
+		if e.ID == "" {
+			e.ID = fmt.Sprintf("edit-%d", i+1)
+		}
+		e.Priority = Priority(strings.ToUpper(strings.TrimSpace(string(e.Priority))))
+		e.Target.Type = strings.ToLower(strings.TrimSpace(e.Target.Type))
+	}
+}
+
+// Validate checks the critique against the schema rules given to the model:
+// scores within 0-MaxScore, at most MaxEdits edits, known priorities and target
+// types, a non-empty instruction, and coordinates normalized to 0-1. All problems
+// are reported.
+func (r *Result) Validate() error {
+	var problems []string
+	if r.Score != nil && (*r.Score < 0 || *r.Score > MaxScore) {
+		problems = append(problems, fmt.Sprintf("score %g is not within 0-%d", *r.Score, MaxScore))
+	}
+	if s := r.Scores; s != nil {
+		criteria := []struct {
+			name string
+			v    *float64
+		}{{"prompt_adherence", &s.PromptAdherence}, {"artifacts", &s.Artifacts}, {"reference_fidelity", s.ReferenceFidelity}}
+		for _, c := range criteria {
+			if c.v != nil && (*c.v < 0 || *c.v > MaxScore) {
+				problems = append(problems, fmt.Sprintf("scores.%s %g is not within 0-%d", c.name, *c.v, MaxScore))
+			}
+		}
+	}
+	if len(r.Edits) > MaxEdits {
+		problems = append(problems, fmt.Sprintf("%d edits exceed the maximum of %d", len(r.Edits), MaxEdits))
+	}
+	for i, e := range r.Edits {
+		at := fmt.Sprintf("edits[%d]", i)
+		switch e.Priority {
+		case PriorityCritical, PriorityMajor, PriorityMinor:
+		default:
+			problems = append(problems, fmt.Sprintf("%s: priority %q is not CRITICAL, MAJOR or MINOR", at, e.Priority))
+		}
+		switch e.Target.Type {
+		case "", "object", "region", "global":
+		default:
+			problems = append(problems, fmt.Sprintf("%s: target type %q is not object, region or global", at, e.Target.Type))
+		}
+		if strings.TrimSpace(e.Instruction) == "" {
+			problems = append(problems, at+": instruction is empty")
+		}
+		if b := e.Target.BBox; b != nil {
+			if !unit(b.X) || !unit(b.Y) || !unit(b.W) || !unit(b.H) || !unit(b.X+b.W) || !unit(b.Y+b.H) {
+				problems = append(problems, fmt.Sprintf("%s: bbox {x:%g y:%g w:%g h:%g} is not within 0-1", at, b.X, b.Y, b.W, b.H))
+			}
+		}
+		for j, p := range e.Target.Points {
+			if !unit(p.X) || !unit(p.Y) {
+				problems = append(problems, fmt.Sprintf("%s: point %d (%g, %g) is not within 0-1", at, j, p.X, p.Y))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(problems, "; "))
+}
+
+// unit reports whether v is a normalized coordinate, allowing for rounding in the
+// model's output.
+func unit(v float64) bool { return v >= -1e-6 && v <= 1+1e-6 }
+
+// JSON returns the critique as indented JSON for inclusion in an improvement prompt.
+func (r *Result) JSON() string {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// BuildRepairInstruction asks the critic to resend a response that failed Parse.
+func BuildRepairInstruction(previous string, err error) string {
+	var b strings.Builder
+	b.WriteString("Your previous critique could not be used: ")
+	b.WriteString(err.Error())
+	b.WriteString("\n\nPrevious response:\n")
+	b.WriteString(strings.TrimSpace(previous))
+	fmt.Fprintf(&b, "\n\nReturn ONLY the corrected JSON object using the exact schema above. No code fences, no prose, coordinates normalized 0-1, at most %d edits, priority one of CRITICAL, MAJOR or MINOR.", MaxEdits)
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package critique
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const validCritique = `{
+  "scores": {"prompt_adherence": 8, "artifacts": 5, "reference_fidelity": null},
+  "keep_notes": ["pose"],
+  "summary_keep": [],
+  "summary_change": ["fix the hands"],
+  "edits": [
+    {
+      "id": "hands",
+      "target": {"type": "object", "label": "left hand", "bbox": {"x": 0.1, "y": 0.5, "w": 0.2, "h": 0.2}, "points": null},
+      "priority": "critical",
+      "instruction": "Redraw the left hand with five fingers.",
+      "rationale": "Six fingers visible.",
+      "done_when": "Five fingers."
+    },
+    {
+      "target": {"type": "global", "label": "lighting", "bbox": null, "points": [{"x": 0.5, "y": 0.5}]},
+      "priority": "MINOR",
+      "instruction": "Warm the lighting slightly."
+    }
+  ]
+}`
+
+func TestParseToleratesFencesAndProse(t *testing.T) {
+	text := "Here is my review {not json}:\n```json\n" + validCritique + "\n```\nLet me know if you need more {details}."
+	r, err := Parse(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Edits) != 2 || r.KeepNotes[0] != "pose" {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	if r.Edits[0].Priority != PriorityCritical {
+		t.Errorf("priority = %q, want CRITICAL", r.Edits[0].Priority)
+	}
+	if rt := r.Rating(); rt == nil || rt.Score != 6.5 {
+		t.Errorf("rating = %v, want the rubric mean 6.5", rt)
+	}
+	if r.Edits[1].ID != "edit-2" {
+		t.Errorf("missing id should be filled in, got %q", r.Edits[1].ID)
+	}
+}
+
+func TestParseRejectsInvalidCritiques(t *testing.T) {
+	cases := map[string]string{
+		"no object":      "The image looks great.",
+		"bad priority":   strings.Replace(validCritique, `"MINOR"`, `"URGENT"`, 1),
+		"bbox outside":   strings.Replace(validCritique, `"w": 0.2`, `"w": 0.95`, 1),
+		"pixel points":   strings.Replace(validCritique, `{"x": 0.5, "y": 0.5}`, `{"x": 512, "y": 300}`, 1),
+		"empty action":   strings.Replace(validCritique, `"Warm the lighting slightly."`, `""`, 1),
+		"score range":    strings.Replace(validCritique, `"artifacts": 5`, `"artifacts": 50`, 1),
+		"too many edits": `{"edits": [` + strings.Repeat(`{"priority":"MINOR","instruction":"x"},`, MaxEdits) + `{"priority":"MINOR","instruction":"x"}]}`,
+	}
+	for name, text := range cases {
+		if _, err := Parse(text); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", name, err)
+		}
+	}
+}
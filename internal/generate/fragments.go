@@ -0,0 +1,26 @@
+package generate
+
+import (
+	"strings"
+)
+
+// LoadFragments resolves fragment references (paths or library names), follows
+// their includes, renders each as a template with vars and returns their trimmed,
+// non-empty contents in order.
+func LoadFragments(refs []string, vars map[string]string) ([]string, error) {
+	frags, err := ExpandFragments(refs)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(frags))
+	for _, f := range frags {
+		s, err := Render("fragment "+f.Name, f.Body, vars)
+		if err != nil {
+			return nil, err
+		}
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
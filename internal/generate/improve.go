@@ -1,6 +1,7 @@
 package generate
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -52,3 +53,9 @@ func BuildImprovementPromptWithActions(originalPrompt, critique, actionsJSON str
 	}
 	return b.String()
 }
+
+// BuildMaskInstruction explains the edit mask that follows the label text
+// (ai.MaskLabel) in the request, after the current and reference images.
+func BuildMaskInstruction(label string) string {
+	return fmt.Sprintf("The image right after the text %q is an edit mask the same size as the image being improved; the other images are the current image and references. Apply the edits only inside the mask's white areas; keep everything under the black areas unchanged, matching the current image exactly.", label)
+}
@@ -0,0 +1,219 @@
+package generate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fragment is a prompt fragment file. Library fragments may start with YAML
+// front matter between --- lines:
+//
+//	---
+//	description: 1960s pop-art comic style
+//	tags: [comic, retro]
+//	aspect_ratio: "16:9"
+//	includes: [base-lighting]
+//	---
+//	Illustrated in the style of ...
+type Fragment struct {
+	Name        string   `yaml:"-"`
+	Path        string   `yaml:"-"`
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	AspectRatio string   `yaml:"aspect_ratio"`
+	Resolution  string   `yaml:"resolution"`
+	// Includes names (or paths relative to this file) of fragments that are
+	// loaded ahead of this one.
+	Includes []string `yaml:"includes"`
+	// Body is the text after the front matter, before template rendering.
+	Body string `yaml:"-"`
+}
+
+// fragmentExts are the file extensions of library fragments, in lookup order.
+var fragmentExts = []string{".txt", ".md"}
+
+// FragmentDirs returns the library directories searched for fragments by name,
+// highest priority first: .nano-agent/fragments in the working directory, then
+// ~/.nano-agent/fragments.
+func FragmentDirs() []string {
+	dirs := []string{filepath.Join(".nano-agent", "fragments")}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".nano-agent", "fragments"))
+	}
+	return dirs
+}
+
+// IsFragmentName reports whether ref is a library name rather than a path.
+func IsFragmentName(ref string) bool {
+	return ref != "" && !strings.ContainsAny(ref, `/\`)
+}
+
+// FindFragment resolves a fragment reference to a file: an existing path is used
+// as is, and a bare name (e.g. comic-style) is looked up in the library.
+func FindFragment(ref string) (string, error) {
+	if _, err := os.Stat(ref); err == nil {
+		return ref, nil
+	}
+	if !IsFragmentName(ref) {
+		return "", fmt.Errorf("fragment file %s not found", ref)
+	}
+	name := strings.TrimSuffix(ref, filepath.Ext(ref))
+	dirs := FragmentDirs()
+	for _, dir := range dirs {
+		for _, ext := range fragmentExts {
+			p := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(p); err == nil {
+				return p, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("fragment %q not found in %s", ref, strings.Join(dirs, " or "))
+}
+
+// ResolveFragments resolves every reference with FindFragment.
+func ResolveFragments(refs []string) ([]string, error) {
+	out := make([]string, 0, len(refs))
+	for _, r := range refs {
+		p, err := FindFragment(r)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// ReadFragment reads a fragment file and parses its front matter, if any. A file
+// that opens with a --- line that does not start a closed YAML mapping (e.g. a
+// markdown horizontal rule) has no front matter; all of it is the body.
+func ReadFragment(path string) (*Fragment, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fragment{
+		Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Path: path,
+		Body: string(b),
+	}
+	text := strings.ReplaceAll(string(b), "\r\n", "\n")
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		meta, body, found := strings.Cut("\n"+rest, "\n---")
+		var doc yaml.Node
+		if !found || yaml.Unmarshal([]byte(meta), &doc) != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			return f, nil
+		}
+		if err := doc.Decode(f); err != nil {
+			return nil, fmt.Errorf("fragment %s: invalid front matter: %w", path, err)
+		}
+		// Drop the rest of the closing --- line.
+		_, body, _ = strings.Cut(body, "\n")
+		f.Body = body
+	}
+	return f, nil
+}
+
+// ExpandFragments resolves refs and follows their includes, returning every
+// fragment once, each preceded by the fragments it includes.
+func ExpandFragments(refs []string) ([]*Fragment, error) {
+	var out []*Fragment
+	done := map[string]bool{}
+	visiting := map[string]bool{}
+	var visit func(ref, from string) error
+	visit = func(ref, from string) error {
+		path, err := findInclude(ref, from)
+		if err != nil {
+			return err
+		}
+		key, _ := filepath.Abs(path)
+		if done[key] {
+			return nil
+		}
+		if visiting[key] {
+			return fmt.Errorf("fragment %s includes itself", path)
+		}
+		visiting[key] = true
+		f, err := ReadFragment(path)
+		if err != nil {
+			return err
+		}
+		for _, inc := range f.Includes {
+			if err := visit(inc, path); err != nil {
+				return err
+			}
+		}
+		visiting[key] = false
+		done[key] = true
+		out = append(out, f)
+		return nil
+	}
+	for _, r := range refs {
+		if err := visit(r, ""); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// findInclude resolves ref, trying paths relative to the including fragment first.
+func findInclude(ref, from string) (string, error) {
+	if from != "" && !filepath.IsAbs(ref) {
+		p := filepath.Join(filepath.Dir(from), ref)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return FindFragment(ref)
+}
+
+// ListFragments returns the fragments in the library, sorted by name. A
+// project-local fragment hides a user-wide one with the same name.
+func ListFragments() ([]*Fragment, error) {
+	seen := map[string]bool{}
+	var out []*Fragment
+	for _, dir := range FragmentDirs() {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			ext := filepath.Ext(e.Name())
+			name := strings.TrimSuffix(e.Name(), ext)
+			if e.IsDir() || seen[name] || !slices.Contains(fragmentExts, ext) {
+				continue
+			}
+			f, err := ReadFragment(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			seen[name] = true
+			out = append(out, f)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// Recommended returns the first aspect ratio and the first resolution recommended
+// by fragments, with the names of the fragments recommending them.
+func Recommended(frags []*Fragment) (aspectRatio, aspectFrom, resolution, resolutionFrom string) {
+	for _, f := range frags {
+		if aspectRatio == "" && f.AspectRatio != "" {
+			aspectRatio, aspectFrom = f.AspectRatio, f.Name
+		}
+		if resolution == "" && f.Resolution != "" {
+			resolution, resolutionFrom = f.Resolution, f.Name
+		}
+	}
+	return
+}
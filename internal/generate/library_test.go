@@ -0,0 +1,82 @@
+package generate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFragmentLibrary(t *testing.T) {
+	home, project := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Chdir(project)
+
+	writeFile(t, filepath.Join(home, ".nano-agent", "fragments", "lighting.txt"), "Hard {{.light}} light.")
+	writeFile(t, filepath.Join(home, ".nano-agent", "fragments", "comic-style.txt"), "user-wide comic style")
+	writeFile(t, filepath.Join(project, ".nano-agent", "fragments", "comic-style.md"), `---
+description: Pop-art comic style
+tags: [comic, retro]
+aspect_ratio: "16:9"
+includes: [lighting]
+---
+Ben-day dots and bold lines.
+`)
+
+	frags, err := ListFragments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range frags {
+		names = append(names, f.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"comic-style", "lighting"}) {
+		t.Fatalf("ListFragments names = %v", names)
+	}
+	// The project-local fragment hides the user-wide one.
+	if frags[0].Description != "Pop-art comic style" || !reflect.DeepEqual(frags[0].Tags, []string{"comic", "retro"}) {
+		t.Fatalf("comic-style = %+v", frags[0])
+	}
+
+	texts, err := LoadFragments([]string{"comic-style"}, map[string]string{"light": "noir"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Hard noir light.", "Ben-day dots and bold lines."}; !reflect.DeepEqual(texts, want) {
+		t.Fatalf("LoadFragments = %q, want %q", texts, want)
+	}
+	expanded, err := ExpandFragments([]string{"comic-style"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar, from, _, _ := Recommended(expanded); ar != "16:9" || from != "comic-style" {
+		t.Fatalf("Recommended = %q from %q", ar, from)
+	}
+
+	if _, err := FindFragment("missing-style"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	// A markdown fragment that opens with a horizontal rule has no front matter.
+	for _, text := range []string{"---\nNo front matter here.\n---\nMore text.", "---\nJust a rule.\n"} {
+		writeFile(t, filepath.Join(project, "rule.md"), text)
+		if f, err := ReadFragment(filepath.Join(project, "rule.md")); err != nil || f.Body != text {
+			t.Fatalf("ReadFragment(%q) = %+v, %v", text, f, err)
+		}
+	}
+	writeFile(t, filepath.Join(project, "loop.txt"), "---\nincludes: [loop.txt]\n---\nx")
+	if _, err := ExpandFragments([]string{"loop.txt"}); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Fatalf("expected an include cycle error, got %v", err)
+	}
+}
@@ -11,3 +11,23 @@ func TestBuildEffectivePrompt(t *testing.T) {
 		t.Fatalf("expected %q, got %q", want, got)
 	}
 }
+
+func TestRender(t *testing.T) {
+	got, err := Render("style", "{{.who}} in {{.where}}", map[string]string{"who": "Dan", "where": "the office"})
+	if err != nil || got != "Dan in the office" {
+		t.Fatalf("Render = %q, %v", got, err)
+	}
+	if _, err := Render("style", "{{.who}} in {{.where}}", map[string]string{"who": "Dan"}); err == nil || err.Error() != `style uses undefined variable "where"` {
+		t.Fatalf("expected an undefined variable error, got %v", err)
+	}
+	if got, err := Render("plain", "no actions here", nil); err != nil || got != "no actions here" {
+		t.Fatalf("Render(plain) = %q, %v", got, err)
+	}
+	// Without variables, literal braces (e.g. a JSON snippet) are left alone.
+	if got, err := Render("json", `reply as {{"mood": "calm"}} or {{.x}}`, nil); err != nil || got != `reply as {{"mood": "calm"}} or {{.x}}` {
+		t.Fatalf("Render(json) = %q, %v", got, err)
+	}
+	if got, err := Render("escaped", `{{"{{"}}.who}} is {{.who}}`, map[string]string{"who": "Dan"}); err != nil || got != "{{.who}} is Dan" {
+		t.Fatalf("Render(escaped) = %q, %v", got, err)
+	}
+}
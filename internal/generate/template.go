@@ -0,0 +1,73 @@
+package generate
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Render executes text as a Go text/template with vars as its data, so prompts and
+// fragments can reference {{.character}}. Referencing a variable that is not set
+// is an error. Without vars, or without template actions, text is returned
+// unchanged, so prompts written before variables existed may hold a literal
+// "{{"; with vars it must be written as {{"{{"}}.
+func Render(name, text string, vars map[string]string) (string, error) {
+	if len(vars) == 0 || !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template in %s: %w", name, err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, vars); err != nil {
+		const missing = `map has no entry for key "`
+		if _, key, ok := strings.Cut(err.Error(), missing); ok {
+			return "", fmt.Errorf("%s uses undefined variable %q", name, strings.TrimSuffix(key, `"`))
+		}
+		return "", fmt.Errorf("failed to render %s: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// ParseVars parses key=value pairs as given to --var.
+func ParseVars(pairs []string) (map[string]string, error) {
+	vars := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		k, v, ok := strings.Cut(p, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid variable %q: use key=value", p)
+		}
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// LoadVars reads a YAML or JSON file mapping variable names to values.
+func LoadVars(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	if err := yaml.Unmarshal(b, &vars); err != nil {
+		return nil, fmt.Errorf("invalid vars file %s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// MergeVars returns the union of sets; later sets override earlier ones.
+func MergeVars(sets ...map[string]string) map[string]string {
+	out := map[string]string{}
+	for _, s := range sets {
+		for k, v := range s {
+			out[k] = v
+		}
+	}
+	return out
+}
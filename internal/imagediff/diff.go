@@ -0,0 +1,57 @@
+// Package imagediff compares generated images locally, without a model call.
+package imagediff
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Decode decodes PNG or JPEG bytes.
+func Decode(b []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	return img, nil
+}
+
+// MeanDelta returns the mean absolute per-channel difference between two encoded
+// images, from 0 (identical pixels) to 1. Images of different sizes are treated
+// as completely different.
+func MeanDelta(a, b []byte) (float64, error) {
+	ia, err := Decode(a)
+	if err != nil {
+		return 0, err
+	}
+	ib, err := Decode(b)
+	if err != nil {
+		return 0, err
+	}
+	ra, rb := ia.Bounds(), ib.Bounds()
+	if ra.Dx() != rb.Dx() || ra.Dy() != rb.Dy() {
+		return 1, nil
+	}
+	var sum float64
+	for y := 0; y < ra.Dy(); y++ {
+		for x := 0; x < ra.Dx(); x++ {
+			r1, g1, b1, _ := ia.At(ra.Min.X+x, ra.Min.Y+y).RGBA()
+			r2, g2, b2, _ := ib.At(rb.Min.X+x, rb.Min.Y+y).RGBA()
+			sum += absDiff(r1, r2) + absDiff(g1, g2) + absDiff(b1, b2)
+		}
+	}
+	n := float64(ra.Dx() * ra.Dy() * 3)
+	if n == 0 {
+		return 0, nil
+	}
+	return sum / n / 0xffff, nil
+}
+
+func absDiff(a, b uint32) float64 {
+	if a > b {
+		return float64(a - b)
+	}
+	return float64(b - a)
+}
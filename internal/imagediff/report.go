@@ -0,0 +1,227 @@
+package imagediff
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+const (
+	// changedDelta is the per-pixel mean channel difference above which a pixel
+	// counts as changed, ignoring re-encoding noise.
+	changedDelta = 0.1
+	// gridSize splits the image into gridSize×gridSize cells to report where it changed.
+	gridSize = 3
+	// ssimBlock is the window size of the block-wise SSIM.
+	ssimBlock = 8
+)
+
+var cellNames = [gridSize][gridSize]string{
+	{"top-left", "top", "top-right"},
+	{"left", "center", "right"},
+	{"bottom-left", "bottom", "bottom-right"},
+}
+
+// Report describes how one image differs from the one before it.
+type Report struct {
+	// MeanDelta is the mean absolute per-channel difference, 0-1.
+	MeanDelta float64
+	// Changed is the fraction of pixels that visibly changed.
+	Changed float64
+	// SSIM is the mean structural similarity of the luminance, 1 for identical images.
+	SSIM float64
+	// HashDistance is the Hamming distance between the images' 64-bit difference
+	// hashes; under about 10 the images look alike.
+	HashDistance int
+	// Cells is the changed fraction per grid cell, indexed [row][column].
+	Cells [gridSize][gridSize]float64
+	// Protected is the changed fraction outside the editable mask given to
+	// Compare, or 0 when no mask was given.
+	Protected float64
+	// SizeChanged is set when the images have different dimensions; only
+	// HashDistance is meaningful then.
+	SizeChanged bool
+}
+
+// Compare measures the change from before to after. When editable is non-nil
+// (white where changes were requested, the size of both images), Protected
+// reports how much changed outside it.
+func Compare(before, after []byte, editable *image.Gray) (*Report, error) {
+	ia, err := Decode(before)
+	if err != nil {
+		return nil, err
+	}
+	ib, err := Decode(after)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{HashDistance: bits.OnesCount64(dHash(ia) ^ dHash(ib))}
+	ba, bb := ia.Bounds(), ib.Bounds()
+	w, h := ba.Dx(), ba.Dy()
+	if w != bb.Dx() || h != bb.Dy() {
+		r.SizeChanged, r.MeanDelta, r.Changed = true, 1, 1
+		return r, nil
+	}
+	if editable != nil && (editable.Rect.Dx() != w || editable.Rect.Dy() != h) {
+		editable = nil
+	}
+
+	la, lb := luma(ia), luma(ib)
+	var sum float64
+	var changed, protected, protectedTotal int
+	var cellChanged, cellTotal [gridSize][gridSize]int
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r1, g1, b1, _ := ia.At(ba.Min.X+x, ba.Min.Y+y).RGBA()
+			r2, g2, b2, _ := ib.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			d := (absDiff(r1, r2) + absDiff(g1, g2) + absDiff(b1, b2)) / 3 / 0xffff
+			sum += d
+			cy, cx := y*gridSize/h, x*gridSize/w
+			cellTotal[cy][cx]++
+			isChanged := d > changedDelta
+			if isChanged {
+				changed++
+				cellChanged[cy][cx]++
+			}
+			if editable != nil && editable.Pix[y*editable.Stride+x] < 128 {
+				protectedTotal++
+				if isChanged {
+					protected++
+				}
+			}
+		}
+	}
+	n := float64(w * h)
+	if n == 0 {
+		return r, nil
+	}
+	r.MeanDelta = sum / n
+	r.Changed = float64(changed) / n
+	for cy := range cellTotal {
+		for cx := range cellTotal[cy] {
+			if cellTotal[cy][cx] > 0 {
+				r.Cells[cy][cx] = float64(cellChanged[cy][cx]) / float64(cellTotal[cy][cx])
+			}
+		}
+	}
+	if protectedTotal > 0 {
+		r.Protected = float64(protected) / float64(protectedTotal)
+	}
+	r.SSIM = ssim(la, lb, w, h)
+	return r, nil
+}
+
+// Where lists the grid cells where more than 5% of pixels changed, most changed first.
+func (r *Report) Where() string {
+	type cell struct {
+		name string
+		v    float64
+	}
+	var cells []cell
+	for cy := range r.Cells {
+		for cx, v := range r.Cells[cy] {
+			if v > 0.05 {
+				cells = append(cells, cell{cellNames[cy][cx], v})
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return "nowhere"
+	}
+	sort.SliceStable(cells, func(i, j int) bool { return cells[i].v > cells[j].v })
+	parts := make([]string, len(cells))
+	for i, c := range cells {
+		parts[i] = fmt.Sprintf("%s %.0f%%", c.name, c.v*100)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *Report) String() string {
+	if r.SizeChanged {
+		return fmt.Sprintf("image size changed (hash distance %d)", r.HashDistance)
+	}
+	return fmt.Sprintf("%.1f%% of pixels changed (mean delta %.1f%%, SSIM %.3f, hash distance %d); where: %s",
+		r.Changed*100, r.MeanDelta*100, r.SSIM, r.HashDistance, r.Where())
+}
+
+// luma returns the image's luminance, 0-1, row-major.
+func luma(img image.Image) []float64 {
+	b := img.Bounds()
+	out := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			out = append(out, (0.299*float64(r)+0.587*float64(g)+0.114*float64(bl))/0xffff)
+		}
+	}
+	return out
+}
+
+// ssim averages the structural similarity of non-overlapping ssimBlock windows.
+func ssim(a, b []float64, w, h int) float64 {
+	const c1, c2 = 0.01 * 0.01, 0.03 * 0.03
+	var total float64
+	var blocks int
+	for by := 0; by+ssimBlock <= h; by += ssimBlock {
+		for bx := 0; bx+ssimBlock <= w; bx += ssimBlock {
+			var ma, mb float64
+			for y := by; y < by+ssimBlock; y++ {
+				for x := bx; x < bx+ssimBlock; x++ {
+					ma += a[y*w+x]
+					mb += b[y*w+x]
+				}
+			}
+			n := float64(ssimBlock * ssimBlock)
+			ma, mb = ma/n, mb/n
+			var va, vb, cov float64
+			for y := by; y < by+ssimBlock; y++ {
+				for x := bx; x < bx+ssimBlock; x++ {
+					da, db := a[y*w+x]-ma, b[y*w+x]-mb
+					va += da * da
+					vb += db * db
+					cov += da * db
+				}
+			}
+			va, vb, cov = va/(n-1), vb/(n-1), cov/(n-1)
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			blocks++
+		}
+	}
+	if blocks == 0 {
+		return 1
+	}
+	return total / float64(blocks)
+}
+
+// dHash is a 64-bit difference hash: the image is averaged down to 9×8 luminance
+// cells and each bit records whether a cell is brighter than its right neighbour.
+func dHash(img image.Image) uint64 {
+	const cw, ch = 9, 8
+	b := img.Bounds()
+	var cells [ch][cw]float64
+	var counts [ch][cw]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * ch / max(1, b.Dy())
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * cw / max(1, b.Dx())
+			r, g, bl, _ := img.At(x, y).RGBA()
+			cells[cy][cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			counts[cy][cx]++
+		}
+	}
+	var hash uint64
+	for y := 0; y < ch; y++ {
+		for x := 0; x < cw-1; x++ {
+			left := cells[y][x] / math.Max(1, float64(counts[y][x]))
+			right := cells[y][x+1] / math.Max(1, float64(counts[y][x+1]))
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
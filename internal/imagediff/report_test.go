@@ -0,0 +1,59 @@
+package imagediff
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func encode(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompare(t *testing.T) {
+	base := image.NewGray(image.Rect(0, 0, 90, 90))
+	for i := range base.Pix {
+		base.Pix[i] = uint8(i % 200)
+	}
+	edited := image.NewGray(base.Rect)
+	copy(edited.Pix, base.Pix)
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 30; x++ {
+			edited.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	same, err := Compare(encode(t, base), encode(t, base), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.Changed != 0 || same.SSIM < 0.999 || same.HashDistance != 0 {
+		t.Fatalf("identical images: %s", same)
+	}
+
+	// Only the center is editable, so the top-left change is all protected area.
+	editable := image.NewGray(base.Rect)
+	for y := 30; y < 60; y++ {
+		for x := 30; x < 60; x++ {
+			editable.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	r, err := Compare(encode(t, base), encode(t, edited), editable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(r.Where(), "top-left") || r.Cells[1][1] != 0 {
+		t.Errorf("expected the change in the top-left cell only, got %s", r.Where())
+	}
+	if r.Protected < 0.1 || r.SSIM >= 1 {
+		t.Errorf("protected=%.3f ssim=%.3f", r.Protected, r.SSIM)
+	}
+}
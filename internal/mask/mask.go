@@ -0,0 +1,189 @@
+// Package mask rasterizes critique regions into edit masks and composites edited
+// regions back onto the previous image.
+package mask
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"github.com/rkirkendall/nano-agent/internal/critique"
+	"github.com/rkirkendall/nano-agent/internal/imagediff"
+)
+
+const (
+	// pad grows every region by this fraction of the shorter image side, since
+	// critics draw tight boxes and edits bleed past them.
+	pad = 0.02
+	// pointRadius is the radius drawn around targets given as one or two points.
+	pointRadius = 0.05
+	// feather is the width of the soft edge, as a fraction of the shorter side.
+	feather = 0.01
+)
+
+// Build rasterizes the bounding boxes and points of edits into a w×h mask: white
+// (255) where the image may change, black elsewhere. Three or more points form a
+// polygon; fewer are drawn as discs. Edits targeting the whole image or giving no
+// geometry are ignored. ok is false when no edit has a region, in which case no
+// mask should be used.
+func Build(w, h int, edits []critique.Edit) (m *image.Gray, ok bool) {
+	m = image.NewGray(image.Rect(0, 0, w, h))
+	short := float64(min(w, h))
+	grow := pad * short
+	for _, e := range edits {
+		if e.Target.Type == "global" {
+			continue
+		}
+		if b := e.Target.BBox; b != nil && b.W > 0 && b.H > 0 {
+			fillRect(m, b.X*float64(w)-grow, b.Y*float64(h)-grow, (b.X+b.W)*float64(w)+grow, (b.Y+b.H)*float64(h)+grow)
+			ok = true
+		}
+		pts := e.Target.Points
+		switch {
+		case len(pts) >= 3:
+			fillPolygon(m, pts, grow)
+			ok = true
+		case len(pts) > 0:
+			for _, p := range pts {
+				fillDisc(m, p.X*float64(w), p.Y*float64(h), pointRadius*short+grow)
+			}
+			ok = true
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+	return blur(m, int(math.Ceil(feather*short))), true
+}
+
+// Coverage returns the fraction of the mask that may change, from 0 to 1.
+func Coverage(m *image.Gray) float64 {
+	var sum int
+	for _, v := range m.Pix {
+		sum += int(v)
+	}
+	return float64(sum) / float64(len(m.Pix)*255)
+}
+
+func fillRect(m *image.Gray, x0, y0, x1, y1 float64) {
+	r := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1))).Intersect(m.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			m.Pix[m.PixOffset(x, y)] = 255
+		}
+	}
+}
+
+func fillDisc(m *image.Gray, cx, cy, r float64) {
+	b := m.Rect
+	for y := max(b.Min.Y, int(cy-r)); y < min(b.Max.Y, int(cy+r)+1); y++ {
+		for x := max(b.Min.X, int(cx-r)); x < min(b.Max.X, int(cx+r)+1); x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy <= r*r {
+				m.Pix[m.PixOffset(x, y)] = 255
+			}
+		}
+	}
+}
+
+// fillPolygon fills the polygon through pts (normalized) with the even-odd rule
+// and pads each vertex with a disc of radius grow.
+func fillPolygon(m *image.Gray, pts []critique.Point, grow float64) {
+	w, h := float64(m.Rect.Dx()), float64(m.Rect.Dy())
+	xs := make([]float64, len(pts))
+	ys := make([]float64, len(pts))
+	for i, p := range pts {
+		xs[i], ys[i] = p.X*w, p.Y*h
+	}
+	for y := 0; y < m.Rect.Dy(); y++ {
+		fy := float64(y) + 0.5
+		for x := 0; x < m.Rect.Dx(); x++ {
+			fx := float64(x) + 0.5
+			inside := false
+			for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+				if (ys[i] > fy) != (ys[j] > fy) && fx < (xs[j]-xs[i])*(fy-ys[i])/(ys[j]-ys[i])+xs[i] {
+					inside = !inside
+				}
+			}
+			if inside {
+				m.Pix[m.PixOffset(x, y)] = 255
+			}
+		}
+	}
+	for i := range pts {
+		fillDisc(m, xs[i], ys[i], grow)
+	}
+}
+
+// blur softens the mask edge with a separable box blur of radius r.
+func blur(m *image.Gray, r int) *image.Gray {
+	if r < 1 {
+		return m
+	}
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	tmp := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum, n := 0, 0
+			for k := max(0, x-r); k <= min(w-1, x+r); k++ {
+				sum += int(m.Pix[y*m.Stride+k])
+				n++
+			}
+			tmp[y*w+x] = sum / n
+		}
+	}
+	out := image.NewGray(m.Rect)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sum, n := 0, 0
+			for k := max(0, y-r); k <= min(h-1, y+r); k++ {
+				sum += tmp[k*w+x]
+				n++
+			}
+			out.Pix[y*out.Stride+x] = uint8(sum / n)
+		}
+	}
+	return out
+}
+
+// Composite blends next over prev through the mask: masked (white) pixels come
+// from next, unmasked pixels from prev, with the feathered edge mixed. Both images
+// must have the mask's size. The result is PNG-encoded.
+func Composite(prev, next []byte, m *image.Gray) ([]byte, error) {
+	ip, err := imagediff.Decode(prev)
+	if err != nil {
+		return nil, err
+	}
+	in, err := imagediff.Decode(next)
+	if err != nil {
+		return nil, err
+	}
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	if ip.Bounds().Dx() != w || ip.Bounds().Dy() != h || in.Bounds().Dx() != w || in.Bounds().Dy() != h {
+		return nil, fmt.Errorf("cannot composite: images are %v and %v, mask is %dx%d", ip.Bounds().Size(), in.Bounds().Size(), w, h)
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	pb, nb := ip.Bounds().Min, in.Bounds().Min
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			a := uint32(m.Pix[y*m.Stride+x])
+			p := color.NRGBAModel.Convert(ip.At(pb.X+x, pb.Y+y)).(color.NRGBA)
+			n := color.NRGBAModel.Convert(in.At(nb.X+x, nb.Y+y)).(color.NRGBA)
+			mix := func(u, v uint8) uint8 { return uint8((uint32(u)*(255-a) + uint32(v)*a + 127) / 255) }
+			out.SetNRGBA(x, y, color.NRGBA{mix(p.R, n.R), mix(p.G, n.G), mix(p.B, n.B), mix(p.A, n.A)})
+		}
+	}
+	return EncodePNG(out)
+}
+
+// EncodePNG encodes img as PNG.
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,54 @@
+package mask
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/rkirkendall/nano-agent/internal/critique"
+	"github.com/rkirkendall/nano-agent/internal/imagediff"
+)
+
+func TestBuildAndComposite(t *testing.T) {
+	edits := []critique.Edit{
+		{Target: critique.Target{Type: "region", BBox: &critique.BBox{X: 0.5, Y: 0.5, W: 0.25, H: 0.25}}},
+		{Target: critique.Target{Type: "global"}},
+	}
+	m, ok := Build(100, 100, edits)
+	if !ok {
+		t.Fatal("expected a mask for a bounding box")
+	}
+	if m.GrayAt(60, 60).Y != 255 || m.GrayAt(10, 10).Y != 0 {
+		t.Fatalf("mask inside=%d outside=%d", m.GrayAt(60, 60).Y, m.GrayAt(10, 10).Y)
+	}
+	if _, ok := Build(100, 100, edits[1:]); ok {
+		t.Fatal("global edits must not produce a mask")
+	}
+
+	solid := func(c color.NRGBA) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, 100, 100))
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+		}
+		b, err := EncodePNG(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	prev, next := solid(color.NRGBA{0, 0, 255, 255}), solid(color.NRGBA{255, 0, 0, 255})
+	out, err := Composite(prev, next, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := imagediff.Decode(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, b, _ := img.At(60, 60).RGBA(); r>>8 != 255 || b != 0 {
+		t.Errorf("masked pixel should come from the new image, got r=%d b=%d", r>>8, b>>8)
+	}
+	if r, _, b, _ := img.At(10, 10).RGBA(); r != 0 || b>>8 != 255 {
+		t.Errorf("unmasked pixel should come from the previous image, got r=%d b=%d", r>>8, b>>8)
+	}
+}
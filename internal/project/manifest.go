@@ -0,0 +1,364 @@
+// Package project loads storyboard/comic manifests: named characters, places and
+// style fragments declared once and referenced by name from each panel.
+package project
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rkirkendall/nano-agent/internal/generate"
+	"gopkg.in/yaml.v3"
+)
+
+// Asset is a named reference image. In YAML it is either a path or a mapping
+// with image and description.
+type Asset struct {
+	Image       string `yaml:"image"`
+	Description string `yaml:"description"`
+}
+
+// UnmarshalYAML accepts the short form `dan: characters/dan.png`.
+func (a *Asset) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		a.Image = n.Value
+		return nil
+	}
+	type plain Asset
+	return n.Decode((*plain)(a))
+}
+
+// Panel is one image to generate.
+type Panel struct {
+	ID         string   `yaml:"id"`
+	Prompt     string   `yaml:"prompt"`
+	Characters []string `yaml:"characters"`
+	Places     []string `yaml:"places"`
+	// Styles names style fragments; when omitted every style in the manifest applies.
+	Styles        []string `yaml:"styles"`
+	Images        []string `yaml:"images"`
+	Output        string   `yaml:"output"`
+	AspectRatio   string   `yaml:"aspect_ratio"`
+	Resolution    string   `yaml:"resolution"`
+	Model         string   `yaml:"model"`
+	CritiqueLoops int      `yaml:"critique_loops"`
+	// Vars are template variables for this panel's prompt and style fragments;
+	// they override the manifest's vars.
+	Vars map[string]string `yaml:"vars"`
+}
+
+// Manifest is a project file. Relative paths are resolved against its directory.
+type Manifest struct {
+	Name        string           `yaml:"name"`
+	Model       string           `yaml:"model"`
+	AspectRatio string           `yaml:"aspect_ratio"`
+	Resolution  string           `yaml:"resolution"`
+	OutputDir   string           `yaml:"output_dir"`
+	Characters  map[string]Asset `yaml:"characters"`
+	Places      map[string]Asset `yaml:"places"`
+	// Styles maps style names to fragment files or fragment library names.
+	Styles map[string]string `yaml:"styles"`
+	Vars   map[string]string `yaml:"vars"`
+	Panels []Panel           `yaml:"panels"`
+	Page   *Page             `yaml:"page"`
+
+	dir string
+}
+
+// Page describes how compose lays finished panels out on a page. Every field is
+// optional; command-line flags override it.
+type Page struct {
+	// Layout is "grid" (Columns per row) or "rows" (Rows, e.g. "2,1").
+	Layout      string `yaml:"layout"`
+	Columns     int    `yaml:"columns"`
+	Rows        string `yaml:"rows"`
+	Size        string `yaml:"size"`
+	DPI         int    `yaml:"dpi"`
+	Gutter      *int   `yaml:"gutter"`
+	Border      *int   `yaml:"border"`
+	Margin      *int   `yaml:"margin"`
+	Background  string `yaml:"background"`
+	BorderColor string `yaml:"border_color"`
+	Fit         string `yaml:"fit"`
+	Output      string `yaml:"output"`
+	// Panels selects and orders the panels on the page; all panels by default.
+	Panels []string `yaml:"panels"`
+}
+
+// Job is a panel with every reference resolved to a path and every default applied.
+type Job struct {
+	ID            string
+	Prompt        string
+	Images        []string
+	Fragments     []string
+	Output        string
+	AspectRatio   string
+	Resolution    string
+	Model         string
+	CritiqueLoops int
+	Vars          map[string]string
+}
+
+// Load reads and validates a manifest: panel ids must be unique and every
+// character, place and style a panel names must be declared.
+func Load(path string) (*Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	m.dir = filepath.Dir(path)
+	if len(m.Panels) == 0 {
+		return nil, fmt.Errorf("manifest %s has no panels", path)
+	}
+	var problems []string
+	seen := map[string]bool{}
+	for i, p := range m.Panels {
+		if p.ID == "" {
+			problems = append(problems, fmt.Sprintf("panels[%d] has no id", i))
+		} else if seen[p.ID] {
+			problems = append(problems, fmt.Sprintf("panel id %q is used twice", p.ID))
+		}
+		seen[p.ID] = true
+		if strings.TrimSpace(p.Prompt) == "" {
+			problems = append(problems, fmt.Sprintf("panel %q has no prompt", p.ID))
+		}
+		problems = append(problems, missing(p.ID, "character", p.Characters, m.Characters)...)
+		problems = append(problems, missing(p.ID, "place", p.Places, m.Places)...)
+		for _, s := range p.Styles {
+			if _, ok := m.Styles[s]; !ok {
+				problems = append(problems, fmt.Sprintf("panel %q uses undeclared style %q", p.ID, s))
+			}
+		}
+	}
+	if m.Page != nil {
+		for _, id := range m.Page.Panels {
+			if !seen[id] {
+				problems = append(problems, fmt.Sprintf("page uses undeclared panel %q", id))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid manifest %s:\n  %s", path, strings.Join(problems, "\n  "))
+	}
+	return &m, nil
+}
+
+func missing(panel, kind string, names []string, declared map[string]Asset) []string {
+	var out []string
+	for _, n := range names {
+		if _, ok := declared[n]; !ok {
+			out = append(out, fmt.Sprintf("panel %q uses undeclared %s %q", panel, kind, n))
+		}
+	}
+	return out
+}
+
+func (m *Manifest) path(p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(m.dir, p)
+}
+
+// fragment resolves a style: a path relative to the manifest, or else a fragment
+// library name.
+func (m *Manifest) fragment(ref string) string {
+	p := m.path(ref)
+	if _, err := os.Stat(p); err != nil && generate.IsFragmentName(ref) {
+		return ref
+	}
+	return p
+}
+
+// Jobs resolves every panel, in manifest order.
+func (m *Manifest) Jobs() []Job {
+	jobs := make([]Job, 0, len(m.Panels))
+	for _, p := range m.Panels {
+		jobs = append(jobs, m.job(p))
+	}
+	return jobs
+}
+
+func (m *Manifest) job(p Panel) Job {
+	j := Job{
+		ID:            p.ID,
+		Model:         first(p.Model, m.Model),
+		AspectRatio:   first(p.AspectRatio, m.AspectRatio),
+		Resolution:    first(p.Resolution, m.Resolution),
+		CritiqueLoops: p.CritiqueLoops,
+		Output:        m.path(p.Output),
+		Vars:          generate.MergeVars(m.Vars, p.Vars),
+	}
+	if j.Output == "" {
+		j.Output = filepath.Join(m.path(first(m.OutputDir, "panels")), p.ID+".png")
+	}
+	var legend []string
+	add := func(name string, a Asset) {
+		j.Images = append(j.Images, m.path(a.Image))
+		line := fmt.Sprintf("- Image %d: %s", len(j.Images), name)
+		if d := strings.TrimSpace(a.Description); d != "" {
+			line += " — " + d
+		}
+		legend = append(legend, line)
+	}
+	for _, c := range p.Characters {
+		add(c, m.Characters[c])
+	}
+	for _, pl := range p.Places {
+		add(pl, m.Places[pl])
+	}
+	for _, img := range p.Images {
+		j.Images = append(j.Images, m.path(img))
+	}
+	styles := p.Styles
+	if styles == nil {
+		for name := range m.Styles {
+			styles = append(styles, name)
+		}
+		sort.Strings(styles)
+	}
+	for _, s := range styles {
+		j.Fragments = append(j.Fragments, m.fragment(m.Styles[s]))
+	}
+	j.Prompt = strings.TrimSpace(p.Prompt)
+	if len(legend) > 0 {
+		j.Prompt += "\n\nReference images:\n" + strings.Join(legend, "\n")
+	}
+	return j
+}
+
+// PageOutputs returns the output paths of the panels on the page, in page order,
+// and the page's own output path (default: page.png in the output directory).
+func (m *Manifest) PageOutputs() ([]string, string) {
+	page := m.Page
+	if page == nil {
+		page = &Page{}
+	}
+	jobs := map[string]Job{}
+	var order []string
+	for _, j := range m.Jobs() {
+		jobs[j.ID] = j
+		order = append(order, j.ID)
+	}
+	if len(page.Panels) > 0 {
+		order = page.Panels
+	}
+	paths := make([]string, 0, len(order))
+	for _, id := range order {
+		paths = append(paths, jobs[id].Output)
+	}
+	output := m.path(page.Output)
+	if output == "" {
+		output = filepath.Join(m.path(first(m.OutputDir, "panels")), "page.png")
+	}
+	return paths, output
+}
+
+func first(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Fingerprint hashes everything that determines a job's output: its settings,
+// prompt, the contents of every input image and fragment, the aspect ratio and
+// resolution recommended by fragment front matter, and extra, which holds
+// settings from outside the manifest such as command-line flags. Callers should
+// fill in a default Model first so that changing the default rebuilds the job.
+func (j Job) Fingerprint(extra any) (string, error) {
+	h := sha256.New()
+	settings, err := json.Marshal([]any{j.Prompt, j.Model, j.AspectRatio, j.Resolution, j.CritiqueLoops, j.Vars, extra})
+	if err != nil {
+		return "", err
+	}
+	h.Write(settings)
+	for _, p := range j.Images {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(b)
+		h.Write(sum[:])
+	}
+	// Front matter can recommend an aspect ratio and resolution for the job.
+	expanded, err := generate.ExpandFragments(j.Fragments)
+	if err != nil {
+		return "", err
+	}
+	ar, _, res, _ := generate.Recommended(expanded)
+	recommended, _ := json.Marshal([]string{ar, res})
+	h.Write(recommended)
+	frags, err := generate.LoadFragments(j.Fragments, j.Vars)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range frags {
+		sum := sha256.Sum256([]byte(f))
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// stateFile records the fingerprint of every panel built from a manifest.
+const stateFile = ".nano-agent-build.json"
+
+// BuildState remembers what each panel was last built from.
+type BuildState struct {
+	Panels map[string]string `json:"panels"`
+
+	path string
+}
+
+// LoadState reads the manifest's build state, or returns an empty one.
+func (m *Manifest) LoadState() (*BuildState, error) {
+	st := &BuildState{Panels: map[string]string{}, path: filepath.Join(m.dir, stateFile)}
+	b, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("invalid build state %s: %w", st.path, err)
+	}
+	if st.Panels == nil {
+		st.Panels = map[string]string{}
+	}
+	return st, nil
+}
+
+// UpToDate reports whether the job's output exists and was built from the same
+// fingerprint.
+func (s *BuildState) UpToDate(j Job, fingerprint string) bool {
+	if s.Panels[j.ID] != fingerprint {
+		return false
+	}
+	_, err := os.Stat(j.Output)
+	return err == nil
+}
+
+// Record stores the fingerprint of a built panel and saves the state.
+func (s *BuildState) Record(id, fingerprint string) error {
+	s.Panels[id] = fingerprint
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, b, 0o644)
+}
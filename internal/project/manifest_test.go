@@ -0,0 +1,67 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadResolvesReferences(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "comic.yaml")
+	if err := os.WriteFile(path, []byte(`
+aspect_ratio: "16:9"
+characters:
+  dan: {image: chars/dan.png, description: tired office worker}
+  barly: chars/barly.png
+places:
+  office: place/office.png
+styles:
+  comic: comic.txt
+  noir: noir.txt
+panels:
+  - id: p1
+    prompt: Dan and Barly toast.
+    characters: [dan, barly]
+    places: [office]
+    styles: [noir]
+    aspect_ratio: "1:1"
+  - id: p2
+    prompt: The empty office.
+    places: [office]
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := m.Jobs()
+	p1, p2 := jobs[0], jobs[1]
+	if want := []string{filepath.Join(dir, "chars/dan.png"), filepath.Join(dir, "chars/barly.png"), filepath.Join(dir, "place/office.png")}; strings.Join(p1.Images, ",") != strings.Join(want, ",") {
+		t.Errorf("images = %v, want %v", p1.Images, want)
+	}
+	if !strings.Contains(p1.Prompt, "- Image 1: dan — tired office worker") || !strings.Contains(p1.Prompt, "- Image 3: office") {
+		t.Errorf("missing reference legend:\n%s", p1.Prompt)
+	}
+	if p1.AspectRatio != "1:1" || p2.AspectRatio != "16:9" {
+		t.Errorf("aspect ratios %q %q", p1.AspectRatio, p2.AspectRatio)
+	}
+	if len(p1.Fragments) != 1 || len(p2.Fragments) != 2 {
+		t.Errorf("p1 should use only noir, p2 every style: %v %v", p1.Fragments, p2.Fragments)
+	}
+	if p2.Output != filepath.Join(dir, "panels", "p2.png") {
+		t.Errorf("default output %q", p2.Output)
+	}
+}
+
+func TestLoadRejectsUndeclaredNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.yaml")
+	if err := os.WriteFile(path, []byte("panels:\n  - id: p1\n    prompt: x\n    characters: [ghost]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), `undeclared character "ghost"`) {
+		t.Fatalf("expected an undeclared character error, got %v", err)
+	}
+}